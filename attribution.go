@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// attributingHost is a thin wrapper around a libp2p host. It intercepts calls
+// to Connect and NewStream, which may dial the peer, to record on behalf of
+// which content and operation the peer is contacted. The events of the
+// connection setup are then attributed to them by the event hub.
+type attributingHost struct {
+	host.Host
+	eventHub *EventHub
+}
+
+func newAttributingHost(h host.Host, eh *EventHub) *attributingHost {
+	return &attributingHost{
+		Host:     h,
+		eventHub: eh,
+	}
+}
+
+func (h *attributingHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	h.eventHub.Attribute(ctx, pi.ID)
+	return h.Host.Connect(ctx, pi)
+}
+
+func (h *attributingHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	h.eventHub.Attribute(ctx, p)
+	return h.Host.NewStream(ctx, p, pids...)
+}
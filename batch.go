@@ -0,0 +1,146 @@
+package main
+
+import (
+	"time"
+)
+
+// BatchSummary holds statistics of a batch of provide operations. All
+// times are in seconds relative to the start of the batch.
+type BatchSummary struct {
+	Contents    int `json:"contents"`
+	Concurrency int `json:"concurrency"`
+	Succeeded   int `json:"succeeded"`
+	Failed      int `json:"failed"`
+
+	// Duration is the time from the start of the batch until the last
+	// provide operation has finished.
+	Duration float64 `json:"duration_s"`
+
+	// Throughput is the number of successful provides per second.
+	Throughput float64 `json:"throughput_per_s"`
+
+	ProvideDuration     Distribution `json:"provide_duration_s"`
+	FindNodeDuration    Distribution `json:"find_node_duration_s"`
+	AddProviderDuration Distribution `json:"add_provider_duration_s"`
+	PeersSent           Distribution `json:"peers_sent"`
+	FindNodeRequests    Distribution `json:"find_node_requests"`
+	AddProviderMessages Distribution `json:"add_provider_messages"`
+	Dials               Distribution `json:"dials"`
+
+	// Provides holds the results of the individual provide operations
+	// in the order they were started.
+	Provides []*BatchProvideSummary `json:"provides"`
+}
+
+// BatchProvideSummary holds the results of a single provide operation of a batch.
+type BatchProvideSummary struct {
+	CID                 string  `json:"cid"`
+	Start               float64 `json:"start_s"`
+	Duration            float64 `json:"duration_s"`
+	FindNodeDuration    float64 `json:"find_node_duration_s"`
+	AddProviderDuration float64 `json:"add_provider_duration_s"`
+	ClosestPeers        int     `json:"closest_peers"`
+	PeersSent           int     `json:"peers_sent"`
+	FindNodeRequests    int     `json:"find_node_requests"`
+	AddProviderMessages int     `json:"add_provider_messages"`
+	Error               string  `json:"error,omitempty"`
+
+	// Dials and DialFailures count the dials that were attributed to the
+	// provide. A dial that concurrent provides wait for is only attributed
+	// to the provide that contacted the peer last.
+	Dials        int `json:"dials"`
+	DialFailures int `json:"dial_failures"`
+}
+
+// NewBatchSummary derives the summary of a batch that started at the given
+// time from the given events. The events are attributed to the individual
+// provide operations by the operation or CID they were tagged with.
+func NewBatchSummary(events []Event, start time.Time, concurrency int) *BatchSummary {
+	return consumeSorted(events).BatchSummary(start, concurrency)
+}
+
+// BatchSummary computes the summary of the batch of provide operations
+// that started at the given time.
+func (ss *StatsSink) BatchSummary(start time.Time, concurrency int) *BatchSummary {
+	bs := &BatchSummary{
+		Concurrency: concurrency,
+		Provides:    []*BatchProvideSummary{},
+	}
+
+	var end time.Time
+	var durations, findNodeDurations, addProviderDurations, stored, requests, messages, dials []float64
+	seen := map[string]struct{}{}
+	for _, stats := range ss.order {
+		if !stats.cid.Defined() {
+			continue
+		}
+		if _, found := seen[stats.cid.String()]; found {
+			continue
+		}
+		seen[stats.cid.String()] = struct{}{}
+
+		bps := &BatchProvideSummary{
+			CID:                 stats.cid.String(),
+			Start:               stats.start.Sub(start).Seconds(),
+			ClosestPeers:        len(stats.closestPeers),
+			FindNodeRequests:    stats.findNodeRequests,
+			AddProviderMessages: stats.addProviderMessages,
+		}
+		bps.Dials, bps.DialFailures = stats.dialCounts()
+		bs.Provides = append(bs.Provides, bps)
+
+		if !stats.lookupDone.IsZero() {
+			bps.FindNodeDuration = stats.lookupDone.Sub(stats.start).Seconds()
+		}
+		if !stats.done {
+			bs.Failed += 1
+			continue
+		}
+
+		bps.Duration = stats.end.Sub(stats.start).Seconds()
+		bps.PeersSent = stats.peersSent
+		if !stats.addProviderStart.IsZero() {
+			bps.AddProviderDuration = stats.end.Sub(stats.addProviderStart).Seconds()
+		}
+		if stats.end.After(end) {
+			end = stats.end
+		}
+		if stats.err != nil {
+			bps.Error = stats.err.Error()
+			bs.Failed += 1
+			continue
+		}
+
+		bs.Succeeded += 1
+		durations = append(durations, bps.Duration)
+		findNodeDurations = append(findNodeDurations, bps.FindNodeDuration)
+		addProviderDurations = append(addProviderDurations, bps.AddProviderDuration)
+		stored = append(stored, float64(bps.PeersSent))
+		requests = append(requests, float64(bps.FindNodeRequests))
+		messages = append(messages, float64(bps.AddProviderMessages))
+		dials = append(dials, float64(bps.Dials))
+	}
+
+	bs.Contents = len(bs.Provides)
+	if !end.IsZero() {
+		bs.Duration = end.Sub(start).Seconds()
+	}
+	if bs.Duration > 0 {
+		bs.Throughput = float64(bs.Succeeded) / bs.Duration
+	}
+
+	bs.ProvideDuration = NewDistribution(durations)
+	bs.FindNodeDuration = NewDistribution(findNodeDurations)
+	bs.AddProviderDuration = NewDistribution(addProviderDurations)
+	bs.PeersSent = NewDistribution(stored)
+	bs.FindNodeRequests = NewDistribution(requests)
+	bs.AddProviderMessages = NewDistribution(messages)
+	bs.Dials = NewDistribution(dials)
+
+	return bs
+}
+
+// Save writes the summary as JSON to the given file.
+func (bs *BatchSummary) Save(filename string) error {
+	return writeJSON(filename, bs)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+func TestNewBatchSummary(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	self := test.RandPeerIDFatal(t)
+	remote := test.RandPeerIDFatal(t)
+	maddr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	find := pb.NewMessage(pb.Message_FIND_NODE, []byte("key"), 0)
+	add := pb.NewMessage(pb.Message_ADD_PROVIDER, []byte("key"), 0)
+
+	a, err := NewRandomContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRandomContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []Event{
+		&ProvideStart{BaseEvent: BaseEvent{ID: self, Time: at(0), CID: a.cid}},
+		&ProvideStart{BaseEvent: BaseEvent{ID: self, Time: at(1), CID: b.cid}},
+		&DialEnd{BaseEvent: BaseEvent{ID: remote, Time: at(2), CID: a.cid}, Transport: "tcp", Maddr: maddr},
+		&DialEnd{BaseEvent: BaseEvent{ID: remote, Time: at(3), CID: b.cid}, Transport: "ws", Maddr: maddr, Err: errors.New("dial backoff")},
+		// Dial of the bootstrap that isn't attributed to any content.
+		&DialEnd{BaseEvent: BaseEvent{ID: remote, Time: at(3)}, Transport: "tcp", Maddr: maddr},
+		&SendRequestStart{BaseEvent: BaseEvent{ID: remote, Time: at(4), CID: a.cid}, Request: find},
+		&LookupDone{BaseEvent: BaseEvent{ID: self, Time: at(10), CID: a.cid}},
+		&AddProviderStart{BaseEvent: BaseEvent{ID: self, Time: at(10), CID: a.cid}},
+		&SendMessageStart{BaseEvent: BaseEvent{ID: remote, Time: at(11), CID: a.cid}, Message: add},
+		&ProvideEnd{BaseEvent: BaseEvent{ID: self, Time: at(20), CID: a.cid}, PeersSent: 1},
+		&ProvideEnd{BaseEvent: BaseEvent{ID: self, Time: at(30), CID: b.cid}, Err: errors.New("failed to find any peer in table")},
+	}
+
+	bs := NewBatchSummary(events, start, 2)
+	if bs.Contents != 2 || bs.Succeeded != 1 || bs.Failed != 1 {
+		t.Fatalf("contents/succeeded/failed = %d/%d/%d, want 2/1/1", bs.Contents, bs.Succeeded, bs.Failed)
+	}
+	if !approx(bs.Duration, 0.03) || !approx(bs.Throughput, 1/0.03) {
+		t.Errorf("duration/throughput = %f/%f, want 0.03/%f", bs.Duration, bs.Throughput, 1/0.03)
+	}
+
+	first, second := bs.Provides[0], bs.Provides[1]
+	if first.CID != a.cid.String() || first.FindNodeRequests != 1 || first.AddProviderMessages != 1 || first.Dials != 1 || first.DialFailures != 0 {
+		t.Errorf("first provide = %+v", first)
+	}
+	if !approx(first.FindNodeDuration, 0.01) || !approx(first.AddProviderDuration, 0.01) {
+		t.Errorf("find node/add provider duration = %f/%f, want 0.01/0.01", first.FindNodeDuration, first.AddProviderDuration)
+	}
+	if second.CID != b.cid.String() || second.Dials != 1 || second.DialFailures != 1 || second.Error == "" {
+		t.Errorf("second provide = %+v", second)
+	}
+}
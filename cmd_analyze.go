@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// AnalyzeCommand reads a previously serialized events file, prints
+// an overview of its contents and renders an HTML report.
+var AnalyzeCommand = &cli.Command{
+	Name:      "analyze",
+	Usage:     "Prints an overview of a previously recorded events file and renders an HTML timeline",
+	ArgsUsage: "[events.csv|events.jsonl|events.parquet]",
+	Action:    AnalyzeAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "report",
+			Usage:       "File the HTML report is written to",
+			EnvVars:     []string{"DPM_REPORT"},
+			DefaultText: "report.html next to the events file",
+		},
+	},
+}
+
+// AnalyzeAction is the function that is called when running `dht-provide-measurement analyze`.
+func AnalyzeAction(c *cli.Context) error {
+	conf, err := ConfigFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	filename := EventsFilename(conf.OutDir, conf.Format)
+	if c.Args().Present() {
+		filename = c.Args().First()
+	}
+
+	rep, err := NewReport(filename)
+	if err != nil {
+		return err
+	}
+
+	types := make([]string, 0, len(rep.EventCounts))
+	for eventType := range rep.EventCounts {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+
+	fmt.Printf("Peers:  %d\n", len(rep.Peers))
+	fmt.Printf("Period: %.3fs - %.3fs\n\n", rep.Start, rep.End)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tCOUNT\tERRORS")
+	for _, eventType := range types {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", eventType, rep.EventCounts[eventType], rep.ErrorCounts[eventType])
+	}
+	fmt.Fprintln(tw)
+
+	if len(rep.ErrorClasses) > 0 {
+		fmt.Fprintln(tw, "TYPE\tERROR CLASS\tCOUNT")
+		for _, eventType := range types {
+			classes := make([]string, 0, len(rep.ErrorClasses[eventType]))
+			for class := range rep.ErrorClasses[eventType] {
+				classes = append(classes, string(class))
+			}
+			sort.Strings(classes)
+
+			for _, class := range classes {
+				fmt.Fprintf(tw, "%s\t%s\t%d\n", eventType, class, rep.ErrorClasses[eventType][ErrorClass(class)])
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintln(tw, "OPERATION\tCOUNT\tMEDIAN\tP90\tMAX")
+	for _, kind := range spanKinds {
+		d := rep.Durations[kind.Name]
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", kind.Label, d.Count, formatSeconds(d.Median), formatSeconds(d.P90), formatSeconds(d.Max))
+	}
+	d := rep.TimeToRecord
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", "Time until record is returned", d.Count, formatSeconds(d.Median), formatSeconds(d.P90), formatSeconds(d.Max))
+
+	// The peers table is only written by newer versions
+	// and can't be found if the events file was moved.
+	peersFile := PeersFilename(filepath.Dir(filename))
+	if _, err = os.Stat(peersFile); err == nil {
+		peers, err := LoadPeers(peersFile)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "AGENT\tPEERS\tFIND_NODE MEDIAN\tFIND_NODE P90\tADD_PROVIDER MEDIAN\tADD_PROVIDER P90")
+		byAgent := rep.DurationsBy(peers, func(pm *PeerMetadata) string { return pm.AgentVersion })
+		for _, bd := range byAgent {
+			req, msg := bd.Durations["request"], bd.Durations["message"]
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", bd.Group, bd.Peers, formatSeconds(req.Median), formatSeconds(req.P90), formatSeconds(msg.Median), formatSeconds(msg.P90))
+		}
+
+		// Only break down the dial durations by location
+		// if the peers were located with a GeoIP database.
+		located := false
+		for _, pm := range peers {
+			located = located || pm.Country != "" || pm.ASN != 0
+		}
+
+		if located {
+			fmt.Fprintln(tw)
+			fmt.Fprintln(tw, "COUNTRY\tPEERS\tDIAL MEDIAN\tDIAL P90\tDIAL MAX")
+			byCountry := rep.DurationsBy(peers, func(pm *PeerMetadata) string { return pm.Country })
+			for _, bd := range byCountry {
+				dial := bd.Durations["dial"]
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", bd.Group, bd.Peers, formatSeconds(dial.Median), formatSeconds(dial.P90), formatSeconds(dial.Max))
+			}
+
+			fmt.Fprintln(tw)
+			fmt.Fprintln(tw, "AS\tPEERS\tDIAL MEDIAN\tDIAL P90\tDIAL MAX")
+			byAS := rep.DurationsBy(peers, func(pm *PeerMetadata) string {
+				if pm.ASN == 0 {
+					return ""
+				}
+				return fmt.Sprintf("AS%d %s", pm.ASN, pm.ASOrg)
+			})
+			for _, bd := range byAS {
+				dial := bd.Durations["dial"]
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", bd.Group, bd.Peers, formatSeconds(dial.Median), formatSeconds(dial.P90), formatSeconds(dial.Max))
+			}
+		}
+	}
+
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+
+	reportFile := c.String("report")
+	if reportFile == "" {
+		reportFile = filepath.Join(filepath.Dir(filename), "report.html")
+	}
+
+	if err = rep.WriteHTML(reportFile); err != nil {
+		return err
+	}
+	log.WithField("file", reportFile).Infoln("Wrote report")
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// ProvideBatchCommand provides many random contents concurrently without
+// monitoring the closest peers. This is used to measure the throughput of
+// provide operations and how their cost scales with the batch size.
+var ProvideBatchCommand = &cli.Command{
+	Name:   "provide-batch",
+	Usage:  "Provides a batch of random contents concurrently and measures the throughput",
+	Action: ProvideBatchAction,
+	Flags: []cli.Flag{
+		gracePeriodFlag,
+		&cli.IntFlag{
+			Name:    "batch-size",
+			Usage:   "How many random contents should be provided",
+			EnvVars: []string{"DPM_BATCH_SIZE"},
+			Value:   100,
+		},
+		&cli.IntFlag{
+			Name:    "concurrency",
+			Usage:   "How many contents are provided at the same time",
+			EnvVars: []string{"DPM_CONCURRENCY"},
+			Value:   10,
+		},
+		simulateFlag,
+		simNodesFlag,
+		simProfileFlag,
+		warmupPeersFlag,
+		warmupBucketsFlag,
+		warmupTimeoutFlag,
+		contentPathFlag,
+		cidListFlag,
+		contentSeedFlag,
+		cidVersionFlag,
+		codecFlag,
+		hashFlag,
+	},
+}
+
+// ProvideBatchAction is the function that is called when running `dht-provide-measurement provide-batch`.
+func ProvideBatchAction(c *cli.Context) error {
+	conf, err := ConfigFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(conf.OutDir, 0o755); err != nil {
+		return errors.Wrap(err, "create output directory")
+	}
+
+	sim, err := NewSimulationFromConfig(c.Context, conf)
+	if err != nil {
+		return err
+	}
+	if sim != nil {
+		defer sim.Close()
+	}
+
+	source, err := NewContentSourceFromConfig(conf)
+	if err != nil {
+		return errors.Wrap(err, "new content source")
+	}
+
+	// Sources of existing data or CIDs may hold fewer contents than the batch size.
+	var contents []*Content
+	for len(contents) < conf.BatchSize {
+		content, err := source.Next()
+		if err == ErrNoMoreContent {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "next content")
+		}
+		contents = append(contents, content)
+	}
+	log.WithField("contents", len(contents)).Infoln("Prepared contents")
+
+	manifest := NewManifest(conf, "provide-batch")
+
+	// Events are tagged with their CID, so the distances are
+	// computed relative to the content they belong to.
+	eh, err := NewEventHubFromConfig(conf, nil, conf.OutDir)
+	if err != nil {
+		return errors.Wrap(err, "new event hub")
+	}
+	// Close the sinks if the command fails before the events are serialized.
+	defer eh.Stop()
+
+	// The contents are spread over the key space, so the
+	// identity of the provider isn't ground for any of them.
+	key, err := NewKeyFromConfig(c.Context, conf, "provider", nil, -1)
+	if err != nil {
+		return errors.Wrap(err, "new provider key")
+	}
+
+	var provider *Provider
+	if sim == nil {
+		provider, err = NewProvider(c.Context, key, conf.Transports, eh)
+	} else {
+		provider, err = NewSimulatedProvider(c.Context, sim, key, eh)
+	}
+	if err != nil {
+		return errors.Wrap(err, "new provider")
+	}
+	defer provider.Close()
+	manifest.ProviderID = provider.h.ID().Pretty()
+
+	if _, err = provider.RestoreState(conf); err != nil {
+		return errors.Wrap(err, "restore provider state")
+	}
+
+	bootstrapPeers := conf.BootstrapPeers
+	if sim != nil {
+		bootstrapPeers = sim.BootstrapPeers()
+	}
+
+	if err = provider.Bootstrap(c.Context, bootstrapPeers); err != nil {
+		return errors.Wrap(err, "bootstrap provider")
+	}
+	manifest.Warmup = provider.WarmUp(c.Context, conf)
+
+	// The contents are spread over the key space, so the
+	// snapshots don't hold the distances of the peers.
+	manifest.RoutingTable = provider.RoutingTableStats()
+	if err = manifest.Save(conf.OutDir); err != nil {
+		return errors.Wrap(err, "save manifest")
+	}
+	snapshots := []*RoutingTableSnapshot{provider.RoutingTableSnapshot("provide_start", nil)}
+
+	log.WithField("concurrency", conf.Concurrency).Infoln("Providing contents")
+	err = provider.ProvideBatch(c.Context, contents, conf.Concurrency)
+
+	// The snapshots are also saved if the batch failed.
+	snapshots = append(snapshots, provider.RoutingTableSnapshot("provide_end", nil))
+	if serr := writeJSON(filepath.Join(conf.OutDir, "routing_tables.json"), snapshots); serr != nil && err == nil {
+		return errors.Wrap(serr, "save routing table snapshots")
+	}
+	if err != nil {
+		return errors.Wrap(err, "provide batch")
+	}
+
+	log.WithField("duration", conf.GracePeriod).Infoln("Provided contents, waiting for grace period")
+	sleepCtx(c.Context, conf.GracePeriod)
+
+	if err = provider.SaveState(conf); err != nil {
+		return errors.Wrap(err, "save provider state")
+	}
+
+	log.Infoln("Serializing events")
+	if err = eh.Stop(); err != nil {
+		return errors.Wrap(err, "stop event hub")
+	}
+	if err = eh.Serialize(nil, EventsFilename(conf.OutDir, conf.Format)); err != nil {
+		return errors.Wrap(err, "serialize events")
+	}
+
+	if err = savePeers(conf, eh, conf.OutDir, provider.h); err != nil {
+		return errors.Wrap(err, "save peers")
+	}
+
+	return eh.SaveBatchSummary(filepath.Join(conf.OutDir, "batch_summary.json"), conf.Concurrency)
+}
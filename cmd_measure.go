@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// MeasureCommand provides random content and monitors the closest
+// peers to that content until they store the provider record.
+var MeasureCommand = &cli.Command{
+	Name:   "measure",
+	Usage:  "Provides random content and monitors when the closest peers store the provider record",
+	Action: MeasureAction,
+	Flags: []cli.Flag{
+		intervalFlag,
+		gracePeriodFlag,
+		reprovidesFlag,
+		reprovideIntervalFlag,
+		&cli.IntFlag{
+			Name:    "runs",
+			Usage:   "How many measurements should be performed back to back",
+			EnvVars: []string{"DPM_RUNS"},
+			Value:   1,
+		},
+		&cli.BoolFlag{
+			Name:    "retrieve",
+			Usage:   "Perform FindProviders lookups with a separate host after the content was provided",
+			EnvVars: []string{"DPM_RETRIEVE"},
+		},
+		&cli.DurationFlag{
+			Name:    "retrieval-interval",
+			Usage:   "How often the FindProviders lookups are repeated during the grace period",
+			EnvVars: []string{"DPM_RETRIEVAL_INTERVAL"},
+			Value:   time.Second,
+		},
+		simulateFlag,
+		simNodesFlag,
+		simProfileFlag,
+		warmupPeersFlag,
+		warmupBucketsFlag,
+		warmupTimeoutFlag,
+		providerCPLFlag,
+		requesterCPLFlag,
+		contentPathFlag,
+		cidListFlag,
+		contentSeedFlag,
+		cidVersionFlag,
+		codecFlag,
+		hashFlag,
+	},
+}
+
+// MeasureAction is the function that is called when running `dht-provide-measurement measure`.
+// Every run writes its events and manifest into its own directory. After all
+// runs have finished an aggregate summary is written to the output directory.
+func MeasureAction(c *cli.Context) error {
+	conf, err := ConfigFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(conf.OutDir, 0o755); err != nil {
+		return errors.Wrap(err, "create output directory")
+	}
+
+	sim, err := NewSimulationFromConfig(c.Context, conf)
+	if err != nil {
+		return err
+	}
+	if sim != nil {
+		defer sim.Close()
+	}
+
+	source, err := NewContentSourceFromConfig(conf)
+	if err != nil {
+		return errors.Wrap(err, "new content source")
+	}
+
+	var manifests []*RunManifest
+	for i := 1; i <= conf.Runs; i++ {
+		if c.Context.Err() != nil {
+			break
+		}
+
+		content, err := source.Next()
+		if err == ErrNoMoreContent {
+			log.WithField("runs", i-1).Infoln("No more content to measure")
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "next content")
+		}
+
+		rm, err := NewRunManifest(conf, i)
+		if err != nil {
+			return err
+		}
+
+		logEntry := log.WithField("run", i).WithField("dir", rm.Dir)
+		logEntry.Infoln("Starting measurement")
+		if err = measure(c.Context, conf, sim, content, rm); err != nil {
+			logEntry.WithError(err).Warnln("Measurement failed")
+			rm.Error = err.Error()
+		}
+		rm.FinishedAt = time.Now()
+
+		if err = rm.Save(); err != nil {
+			return errors.Wrap(err, "save run manifest")
+		}
+		manifests = append(manifests, rm)
+	}
+
+	summary := NewAggregateSummary(manifests)
+	log.WithField("runs", summary.Runs).
+		WithField("failed", summary.FailedRuns).
+		WithField("median", summary.ProvideDuration.Median).
+		Infoln("Finished all measurements")
+
+	return summary.Save(conf.OutDir)
+}
+
+// measure performs a single provide operation of the given content while
+// monitoring the closest peers for the provider record. All events are written
+// to the run directory and the results are recorded in the given run manifest.
+// If sim is not nil the measurement is performed in the simulated network.
+func measure(ctx context.Context, conf *Config, sim *Simulation, content *Content, rm *RunManifest) error {
+	log.WithField("cid", content.cid.String()).Infof("Measuring content")
+	rm.CID = content.cid.String()
+
+	eh, err := NewEventHubFromConfig(conf, content, rm.Dir)
+	if err != nil {
+		return errors.Wrap(err, "new event hub")
+	}
+
+	hosts := &measurementHosts{}
+	defer hosts.Close()
+
+	// The events, peers and summary are also saved if the measurement
+	// failed, because they are needed to understand the failure.
+	err = runMeasurement(ctx, conf, sim, content, rm, eh, hosts)
+	if serr := saveMeasurement(conf, content, rm, eh, hosts); serr != nil {
+		if err == nil {
+			return serr
+		}
+		log.WithError(serr).Warnln("Could not save the results of the failed measurement")
+	}
+
+	return err
+}
+
+// measurementHosts holds the libp2p hosts of a measurement. The hosts are
+// kept open until the results of the measurement were saved.
+type measurementHosts struct {
+	provider  *Provider
+	requester *Requester
+	retriever *Requester
+}
+
+// Hosts returns the libp2p hosts that were constructed.
+func (mh *measurementHosts) Hosts() []host.Host {
+	var hosts []host.Host
+	if mh.provider != nil {
+		hosts = append(hosts, mh.provider.h)
+	}
+	if mh.requester != nil {
+		hosts = append(hosts, mh.requester.h)
+	}
+	if mh.retriever != nil {
+		hosts = append(hosts, mh.retriever.h)
+	}
+	return hosts
+}
+
+// Close shuts down all hosts that were constructed.
+func (mh *measurementHosts) Close() {
+	if mh.retriever != nil {
+		mh.retriever.Close()
+	}
+	if mh.provider != nil {
+		mh.provider.Close()
+	}
+	if mh.requester != nil {
+		mh.requester.Close()
+	}
+}
+
+// runMeasurement constructs the hosts of the measurement, provides the given
+// content and monitors the closest peers until the grace period has passed.
+func runMeasurement(ctx context.Context, conf *Config, sim *Simulation, content *Content, rm *RunManifest, eh *EventHub, hosts *measurementHosts) error {
+	// Construct the requester libp2p host
+	requesterKey, err := NewKeyFromConfig(ctx, conf, "requester", content, conf.RequesterCPL)
+	if err != nil {
+		return errors.Wrap(err, "new requester key")
+	}
+	var requester *Requester
+	if sim == nil {
+		requester, err = NewRequester(ctx, requesterKey, eh)
+	} else {
+		requester, err = NewSimulatedRequester(ctx, sim, requesterKey, eh)
+	}
+	if err != nil {
+		return errors.Wrap(err, "new requester")
+	}
+	hosts.requester = requester
+	rm.RequesterID = requester.h.ID().Pretty()
+	rm.RequesterCPL = CommonPrefixLen(requester.h.ID(), content)
+
+	// Construct the provider libp2p host
+	providerKey, err := NewKeyFromConfig(ctx, conf, "provider", content, conf.ProviderCPL)
+	if err != nil {
+		return errors.Wrap(err, "new provider key")
+	}
+	var provider *Provider
+	if sim == nil {
+		provider, err = NewProvider(ctx, providerKey, conf.Transports, eh)
+	} else {
+		provider, err = NewSimulatedProvider(ctx, sim, providerKey, eh)
+	}
+	if err != nil {
+		return errors.Wrap(err, "new provider")
+	}
+	hosts.provider = provider
+	rm.ProviderID = provider.h.ID().Pretty()
+	rm.ProviderCPL = CommonPrefixLen(provider.h.ID(), content)
+
+	// Construct a separate requester libp2p host that looks up
+	// the providers of the content after it was provided.
+	var retriever *Requester
+	if conf.Retrieve {
+		retrieverKey, err := NewKeyFromConfig(ctx, conf, "retriever", content, conf.RequesterCPL)
+		if err != nil {
+			return errors.Wrap(err, "new retriever key")
+		}
+		if sim == nil {
+			retriever, err = NewRequester(ctx, retrieverKey, eh)
+		} else {
+			retriever, err = NewSimulatedRequester(ctx, sim, retrieverKey, eh)
+		}
+		if err != nil {
+			return errors.Wrap(err, "new retriever")
+		}
+		hosts.retriever = retriever
+		rm.RetrieverID = retriever.h.ID().Pretty()
+	}
+
+	// Start with the routing tables of the previous run if they were kept.
+	if rm.RestoredPeers, err = provider.RestoreState(conf); err != nil {
+		return errors.Wrap(err, "restore provider state")
+	}
+	if _, err = requester.RestoreState(conf, "requester"); err != nil {
+		return errors.Wrap(err, "restore requester state")
+	}
+	if retriever != nil {
+		if _, err = retriever.RestoreState(conf, "retriever"); err != nil {
+			return errors.Wrap(err, "restore retriever state")
+		}
+	}
+
+	bootstrapPeers := conf.BootstrapPeers
+	if sim != nil {
+		bootstrapPeers = sim.BootstrapPeers()
+	}
+
+	// Bootstrap both libp2p hosts by connecting to the bootstrap peers.
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		return provider.Bootstrap(groupCtx, bootstrapPeers)
+	})
+	group.Go(func() error {
+		return requester.Bootstrap(groupCtx, bootstrapPeers)
+	})
+	if retriever != nil {
+		group.Go(func() error {
+			return retriever.Bootstrap(groupCtx, bootstrapPeers)
+		})
+	}
+	if err = group.Wait(); err != nil {
+		return errors.Wrap(err, "bootstrap err group")
+	}
+
+	// Refresh the routing table of the provider, so that the
+	// measurement isn't dominated by cold-start effects.
+	rm.Warmup = provider.WarmUp(ctx, conf)
+
+	// Start pinging the closest peers to the random content from above for provider records.
+	monitorCtx, stopMonitoring := context.WithCancel(ctx)
+	defer stopMonitoring()
+	if _, err = requester.MonitorProviders(monitorCtx, content, conf.MonitorInterval); err != nil {
+		return errors.Wrap(err, "monitor provider")
+	}
+
+	// Provide the content from above and capture what both
+	// hosts knew about the network before and after.
+	rm.RoutingTable = provider.RoutingTableStats()
+	snapshots := []*RoutingTableSnapshot{
+		provider.RoutingTableSnapshot("provide_start", content),
+		requester.RoutingTableSnapshot("requester", "provide_start", content),
+	}
+	start := time.Now()
+	err = provider.Provide(ctx, content)
+	rm.ProvideDuration = time.Since(start).Seconds()
+
+	// The snapshots are also saved if the provide failed, because they
+	// show which peers the provider knew. The events and the summary
+	// are saved by the caller in any case.
+	snapshots = append(snapshots,
+		provider.RoutingTableSnapshot("provide_end", content),
+		requester.RoutingTableSnapshot("requester", "provide_end", content),
+	)
+	if serr := writeJSON(filepath.Join(rm.Dir, "routing_tables.json"), snapshots); serr != nil && err == nil {
+		return errors.Wrap(serr, "save routing table snapshots")
+	}
+	if err != nil {
+		return errors.Wrap(err, "provide")
+	}
+
+	if err = provider.ReprovideRounds(ctx, content, conf.Reprovides, conf.ReprovideInterval); err != nil {
+		return err
+	}
+
+	log.WithField("duration", conf.GracePeriod).Infoln("Provided content, waiting for grace period")
+	if retriever != nil {
+		// Look up the providers of the content until the grace period has passed.
+		retrievalCtx, stopRetrieval := context.WithTimeout(ctx, conf.GracePeriod)
+		defer stopRetrieval()
+		<-retriever.MonitorRetrieval(retrievalCtx, content, conf.RetrievalInterval)
+	} else {
+		sleepCtx(ctx, conf.GracePeriod)
+	}
+
+	if err = provider.SaveState(conf); err != nil {
+		return errors.Wrap(err, "save provider state")
+	}
+	if err = requester.SaveState(conf, "requester"); err != nil {
+		return errors.Wrap(err, "save requester state")
+	}
+	if retriever != nil {
+		if err = retriever.SaveState(conf, "retriever"); err != nil {
+			return errors.Wrap(err, "save retriever state")
+		}
+	}
+
+	return nil
+}
+
+// saveMeasurement stops the event hub and writes the events, the peers and the
+// provide summary of the measurement into the run directory. The monitor
+// results are recorded in the given run manifest.
+func saveMeasurement(conf *Config, content *Content, rm *RunManifest, eh *EventHub, hosts *measurementHosts) error {
+	log.Infoln("Serializing events")
+	if err := eh.Stop(); err != nil {
+		return errors.Wrap(err, "stop event hub")
+	}
+
+	filename := EventsFilename(rm.Dir, conf.Format)
+	if err := eh.Serialize(content, filename); err != nil {
+		return errors.Wrap(err, "serialize events")
+	}
+
+	if err := savePeers(conf, eh, rm.Dir, hosts.Hosts()...); err != nil {
+		return errors.Wrap(err, "save peers")
+	}
+
+	if err := eh.SaveSummary(filepath.Join(rm.Dir, "provide_summary.json")); err != nil {
+		return errors.Wrap(err, "save provide summary")
+	}
+
+	return rm.CountMonitorResults(filename)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// MonitorOnlyCommand monitors the closest peers to a CID that
+// is provided by somebody else until they store the provider record.
+var MonitorOnlyCommand = &cli.Command{
+	Name:   "monitor-only",
+	Usage:  "Monitors when the closest peers to an externally provided CID store the provider record",
+	Action: MonitorOnlyAction,
+	Flags: []cli.Flag{
+		intervalFlag,
+		requesterCPLFlag,
+		&cli.StringFlag{
+			Name:     "cid",
+			Usage:    "The CID whose provider records should be monitored",
+			EnvVars:  []string{"DPM_CID"},
+			Required: true,
+		},
+	},
+}
+
+// MonitorOnlyAction is the function that is called when running `dht-provide-measurement monitor-only`.
+func MonitorOnlyAction(c *cli.Context) error {
+	conf, err := ConfigFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(conf.OutDir, 0o755); err != nil {
+		return errors.Wrap(err, "create output directory")
+	}
+
+	contentID, err := cid.Decode(c.String("cid"))
+	if err != nil {
+		return errors.Wrap(err, "decode cid")
+	}
+	content := NewContentFromCID(contentID)
+
+	eh, err := NewEventHubFromConfig(conf, content, conf.OutDir)
+	if err != nil {
+		return errors.Wrap(err, "new event hub")
+	}
+	// Close the sinks if the command fails before the events are serialized.
+	defer eh.Stop()
+
+	key, err := NewKeyFromConfig(c.Context, conf, "requester", content, conf.RequesterCPL)
+	if err != nil {
+		return errors.Wrap(err, "new requester key")
+	}
+
+	requester, err := NewRequester(c.Context, key, eh)
+	if err != nil {
+		return errors.Wrap(err, "new requester")
+	}
+	defer requester.Close()
+
+	if _, err = requester.RestoreState(conf, "requester"); err != nil {
+		return errors.Wrap(err, "restore requester state")
+	}
+
+	if err = requester.Bootstrap(c.Context, conf.BootstrapPeers); err != nil {
+		return errors.Wrap(err, "bootstrap requester")
+	}
+
+	ctx := eh.Start(c.Context, requester.h)
+	done, err := requester.MonitorProviders(ctx, content, conf.MonitorInterval)
+	if err != nil {
+		return errors.Wrap(err, "monitor provider")
+	}
+
+	log.Infoln("Waiting until all peers returned the provider record")
+	<-done
+
+	if err = requester.SaveState(conf, "requester"); err != nil {
+		return errors.Wrap(err, "save requester state")
+	}
+
+	log.Infoln("Serializing events")
+	if err = eh.Stop(); err != nil {
+		return errors.Wrap(err, "stop event hub")
+	}
+	if err = eh.Serialize(content, EventsFilename(conf.OutDir, conf.Format)); err != nil {
+		return errors.Wrap(err, "serialize events")
+	}
+
+	return savePeers(conf, eh, conf.OutDir, requester.h)
+}
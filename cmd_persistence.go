@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// PersistenceCommand provides random content and monitors the provider
+// record at the closest peers over its full lifetime.
+var PersistenceCommand = &cli.Command{
+	Name:   "persistence",
+	Usage:  "Provides random content and monitors how long the closest peers keep the provider record",
+	Action: PersistenceAction,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:        "duration",
+			Usage:       "How long the provider record is monitored",
+			EnvVars:     []string{"DPM_DURATION"},
+			Value:       providers.ProvideValidity + time.Hour,
+			DefaultText: "provider record lifetime + 1h",
+		},
+		&cli.DurationFlag{
+			Name:    "interval",
+			Usage:   "How often every peer is asked for the provider record",
+			EnvVars: []string{"DPM_INTERVAL"},
+			Value:   time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:    "closest-interval",
+			Usage:   "How often the closest peers are looked up again to detect churn",
+			EnvVars: []string{"DPM_CLOSEST_INTERVAL"},
+			Value:   10 * time.Minute,
+		},
+		&cli.StringFlag{
+			Name:    "cid",
+			Usage:   "Monitor the provider records of an externally provided CID instead of providing random content",
+			EnvVars: []string{"DPM_CID"},
+		},
+		simulateFlag,
+		simNodesFlag,
+		simProfileFlag,
+		warmupPeersFlag,
+		warmupBucketsFlag,
+		warmupTimeoutFlag,
+		providerCPLFlag,
+		requesterCPLFlag,
+		contentPathFlag,
+		cidListFlag,
+		contentSeedFlag,
+		cidVersionFlag,
+		codecFlag,
+		hashFlag,
+	},
+}
+
+// PersistenceAction is the function that is called when running `dht-provide-measurement persistence`.
+func PersistenceAction(c *cli.Context) error {
+	conf, err := ConfigFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(conf.OutDir, 0o755); err != nil {
+		return errors.Wrap(err, "create output directory")
+	}
+
+	sim, err := NewSimulationFromConfig(c.Context, conf)
+	if err != nil {
+		return err
+	}
+	if sim != nil {
+		defer sim.Close()
+	}
+
+	var content *Content
+	if c.IsSet("cid") {
+		contentID, err := cid.Decode(c.String("cid"))
+		if err != nil {
+			return errors.Wrap(err, "decode cid")
+		}
+		content = NewContentFromCID(contentID)
+	} else {
+		source, err := NewContentSourceFromConfig(conf)
+		if err != nil {
+			return errors.Wrap(err, "new content source")
+		}
+		if content, err = source.Next(); err != nil {
+			return errors.Wrap(err, "next content")
+		}
+	}
+	log.WithField("cid", content.cid.String()).Infof("Monitoring content")
+
+	manifest := NewManifest(conf, "persistence")
+	manifest.CID = content.cid.String()
+
+	eh, err := NewEventHubFromConfig(conf, content, conf.OutDir)
+	if err != nil {
+		return errors.Wrap(err, "new event hub")
+	}
+	// Close the sinks if the command fails before the events are serialized.
+	defer eh.Stop()
+
+	requesterKey, err := NewKeyFromConfig(c.Context, conf, "requester", content, conf.RequesterCPL)
+	if err != nil {
+		return errors.Wrap(err, "new requester key")
+	}
+	var requester *Requester
+	if sim == nil {
+		requester, err = NewRequester(c.Context, requesterKey, eh)
+	} else {
+		requester, err = NewSimulatedRequester(c.Context, sim, requesterKey, eh)
+	}
+	if err != nil {
+		return errors.Wrap(err, "new requester")
+	}
+	defer requester.Close()
+	manifest.RequesterID = requester.h.ID().Pretty()
+
+	if _, err = requester.RestoreState(conf, "requester"); err != nil {
+		return errors.Wrap(err, "restore requester state")
+	}
+
+	bootstrapPeers := conf.BootstrapPeers
+	if sim != nil {
+		bootstrapPeers = sim.BootstrapPeers()
+	}
+
+	group, groupCtx := errgroup.WithContext(c.Context)
+	group.Go(func() error {
+		return requester.Bootstrap(groupCtx, bootstrapPeers)
+	})
+
+	var provider *Provider
+	if !c.IsSet("cid") {
+		providerKey, err := NewKeyFromConfig(c.Context, conf, "provider", content, conf.ProviderCPL)
+		if err != nil {
+			return errors.Wrap(err, "new provider key")
+		}
+		if sim == nil {
+			provider, err = NewProvider(c.Context, providerKey, conf.Transports, eh)
+		} else {
+			provider, err = NewSimulatedProvider(c.Context, sim, providerKey, eh)
+		}
+		if err != nil {
+			return errors.Wrap(err, "new provider")
+		}
+		defer provider.Close()
+		manifest.ProviderID = provider.h.ID().Pretty()
+
+		if _, err = provider.RestoreState(conf); err != nil {
+			return errors.Wrap(err, "restore provider state")
+		}
+
+		group.Go(func() error {
+			return provider.Bootstrap(groupCtx, bootstrapPeers)
+		})
+	}
+
+	if err = group.Wait(); err != nil {
+		return errors.Wrap(err, "bootstrap err group")
+	}
+
+	if provider == nil {
+		if err = manifest.Save(conf.OutDir); err != nil {
+			return errors.Wrap(err, "save manifest")
+		}
+		snapshots := []*RoutingTableSnapshot{requester.RoutingTableSnapshot("requester", "monitor_start", content)}
+		if err = writeJSON(filepath.Join(conf.OutDir, "routing_tables.json"), snapshots); err != nil {
+			return errors.Wrap(err, "save routing table snapshots")
+		}
+		eh.Start(c.Context, requester.h)
+	} else {
+		manifest.Warmup = provider.WarmUp(c.Context, conf)
+		manifest.RoutingTable = provider.RoutingTableStats()
+		if err = manifest.Save(conf.OutDir); err != nil {
+			return errors.Wrap(err, "save manifest")
+		}
+
+		snapshots := []*RoutingTableSnapshot{
+			provider.RoutingTableSnapshot("provide_start", content),
+			requester.RoutingTableSnapshot("requester", "provide_start", content),
+		}
+		err = provider.Provide(c.Context, content)
+
+		// The snapshots are also saved if the provide failed.
+		snapshots = append(snapshots,
+			provider.RoutingTableSnapshot("provide_end", content),
+			requester.RoutingTableSnapshot("requester", "provide_end", content),
+		)
+		if serr := writeJSON(filepath.Join(conf.OutDir, "routing_tables.json"), snapshots); serr != nil && err == nil {
+			return errors.Wrap(serr, "save routing table snapshots")
+		}
+		if err != nil {
+			return errors.Wrap(err, "provide")
+		}
+	}
+
+	log.WithField("duration", c.Duration("duration")).Infoln("Monitoring provider record persistence")
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("duration"))
+	defer cancel()
+
+	pm := NewPersistenceMonitor(requester, content, eh.startTime)
+	pm.Run(ctx, conf.MonitorInterval, c.Duration("closest-interval"))
+
+	if err = requester.SaveState(conf, "requester"); err != nil {
+		return errors.Wrap(err, "save requester state")
+	}
+	if provider != nil {
+		if err = provider.SaveState(conf); err != nil {
+			return errors.Wrap(err, "save provider state")
+		}
+	}
+
+	log.Infoln("Serializing events")
+	if err = eh.Stop(); err != nil {
+		return errors.Wrap(err, "stop event hub")
+	}
+
+	if err = eh.Serialize(content, EventsFilename(conf.OutDir, conf.Format)); err != nil {
+		return errors.Wrap(err, "serialize events")
+	}
+
+	hosts := []host.Host{requester.h}
+	if provider != nil {
+		hosts = append(hosts, provider.h)
+	}
+	if err = savePeers(conf, eh, conf.OutDir, hosts...); err != nil {
+		return errors.Wrap(err, "save peers")
+	}
+
+	return pm.Save(filepath.Join(conf.OutDir, "persistence.json"))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// ProvideOnlyCommand provides random content without monitoring
+// the closest peers. This is useful if the monitoring happens on
+// a different machine.
+var ProvideOnlyCommand = &cli.Command{
+	Name:   "provide-only",
+	Usage:  "Provides random content without monitoring the closest peers",
+	Action: ProvideOnlyAction,
+	Flags: []cli.Flag{
+		gracePeriodFlag,
+		reprovidesFlag,
+		reprovideIntervalFlag,
+		warmupPeersFlag,
+		warmupBucketsFlag,
+		warmupTimeoutFlag,
+		providerCPLFlag,
+		contentPathFlag,
+		cidListFlag,
+		contentSeedFlag,
+		cidVersionFlag,
+		codecFlag,
+		hashFlag,
+	},
+}
+
+// ProvideOnlyAction is the function that is called when running `dht-provide-measurement provide-only`.
+func ProvideOnlyAction(c *cli.Context) error {
+	conf, err := ConfigFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(conf.OutDir, 0o755); err != nil {
+		return errors.Wrap(err, "create output directory")
+	}
+
+	source, err := NewContentSourceFromConfig(conf)
+	if err != nil {
+		return errors.Wrap(err, "new content source")
+	}
+
+	content, err := source.Next()
+	if err != nil {
+		return errors.Wrap(err, "next content")
+	}
+	log.WithField("cid", content.cid.String()).Infof("Providing content")
+
+	eh, err := NewEventHubFromConfig(conf, content, conf.OutDir)
+	if err != nil {
+		return errors.Wrap(err, "new event hub")
+	}
+	// Close the sinks if the command fails before the events are serialized.
+	defer eh.Stop()
+
+	key, err := NewKeyFromConfig(c.Context, conf, "provider", content, conf.ProviderCPL)
+	if err != nil {
+		return errors.Wrap(err, "new provider key")
+	}
+
+	provider, err := NewProvider(c.Context, key, conf.Transports, eh)
+	if err != nil {
+		return errors.Wrap(err, "new provider")
+	}
+	defer provider.Close()
+
+	if _, err = provider.RestoreState(conf); err != nil {
+		return errors.Wrap(err, "restore provider state")
+	}
+
+	if err = provider.Bootstrap(c.Context, conf.BootstrapPeers); err != nil {
+		return errors.Wrap(err, "bootstrap provider")
+	}
+	provider.WarmUp(c.Context, conf)
+
+	snapshots := []*RoutingTableSnapshot{provider.RoutingTableSnapshot("provide_start", content)}
+	err = provider.Provide(c.Context, content)
+
+	// The snapshots are also saved if the provide failed.
+	snapshots = append(snapshots, provider.RoutingTableSnapshot("provide_end", content))
+	if serr := writeJSON(filepath.Join(conf.OutDir, "routing_tables.json"), snapshots); serr != nil && err == nil {
+		return errors.Wrap(serr, "save routing table snapshots")
+	}
+	if err != nil {
+		return errors.Wrap(err, "provide")
+	}
+
+	if err = provider.ReprovideRounds(c.Context, content, conf.Reprovides, conf.ReprovideInterval); err != nil {
+		return err
+	}
+
+	log.WithField("duration", conf.GracePeriod).Infoln("Provided content, waiting for grace period")
+	sleepCtx(c.Context, conf.GracePeriod)
+
+	if err = provider.SaveState(conf); err != nil {
+		return errors.Wrap(err, "save provider state")
+	}
+
+	log.Infoln("Serializing events")
+	if err = eh.Stop(); err != nil {
+		return errors.Wrap(err, "stop event hub")
+	}
+	if err = eh.Serialize(content, EventsFilename(conf.OutDir, conf.Format)); err != nil {
+		return errors.Wrap(err, "serialize events")
+	}
+
+	if err = savePeers(conf, eh, conf.OutDir, provider.h); err != nil {
+		return errors.Wrap(err, "save peers")
+	}
+
+	return eh.SaveSummary(filepath.Join(conf.OutDir, "provide_summary.json"))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// Config holds the user configuration that is shared by all commands.
+type Config struct {
+	// The peers both libp2p hosts connect to before measuring.
+	BootstrapPeers []peer.AddrInfo
+
+	// The directory where the measurement output is written to.
+	OutDir string
+
+	// Where events are collected during a measurement: "memory" or "file".
+	Sink string
+
+	// The serialization format of the events file: "csv", "jsonl" or "parquet".
+	Format string
+
+	// TCP address to which all events are streamed as they are recorded.
+	ExportAddr string
+
+	// How often the requester asks the closest peers for provider records.
+	MonitorInterval time.Duration
+
+	// How long to keep recording events after the provide operation has finished.
+	GracePeriod time.Duration
+
+	// How many measurements should be performed back to back.
+	Runs int
+
+	// How often the content is provided again after the initial provide.
+	Reprovides int
+
+	// How long to wait before each reprovide.
+	ReprovideInterval time.Duration
+
+	// Whether a separate requester host performs FindProviders
+	// lookups for the content after it was provided.
+	Retrieve bool
+
+	// How often the FindProviders lookups are repeated during the grace period.
+	RetrievalInterval time.Duration
+
+	// The number of contents that are provided in a batch.
+	BatchSize int
+
+	// How many contents of a batch are provided concurrently.
+	Concurrency int
+
+	// A file or directory whose data is provided instead of random content.
+	ContentPath string
+
+	// A file with one CID per line that is provided instead of random content.
+	CIDList string
+
+	// If set, the random content is derived from this seed, so it's
+	// the same for runs with the same seed.
+	ContentSeed *int64
+
+	// The version, codec and hash function of the CIDs of generated content.
+	CIDFormat CIDFormat
+
+	// The type of the keys that are generated for the hosts.
+	KeyType int
+
+	// The names of the transports the provider dials and listens with.
+	Transports []string
+
+	// The directory in which the keys, routing tables and peerstores of
+	// the hosts are kept between runs. Empty if every run starts cold.
+	StateDir string
+
+	// The common prefix length that the peer IDs of the provider and
+	// requester hosts share with the content. Negative values mean
+	// that random identities are used.
+	ProviderCPL  int
+	RequesterCPL int
+
+	// The routing table of the provider is refreshed until it holds at least
+	// WarmupPeers peers and the buckets of the WarmupBuckets lowest common
+	// prefix lengths are full, but no longer than WarmupTimeout.
+	WarmupPeers   int
+	WarmupBuckets int
+	WarmupTimeout time.Duration
+
+	// Paths to local MaxMind city and ASN databases that are used to
+	// enrich the peers table. Empty if the lookup should be skipped.
+	GeoIPCityDB string
+	GeoIPASNDB  string
+
+	// Whether to measure against an in-process simulated DHT network
+	// instead of the live IPFS network.
+	Simulate bool
+
+	// The number of DHT server nodes in the simulated network.
+	SimNodes int
+
+	// Path to a JSON file describing the network conditions of the simulated network.
+	SimProfile string
+}
+
+// ConfigFromContext reads the flags of the given command line context
+// into a new Config struct.
+func ConfigFromContext(c *cli.Context) (*Config, error) {
+	conf := &Config{
+		BootstrapPeers:    kaddht.GetDefaultBootstrapPeerAddrInfos(),
+		OutDir:            c.String("out"),
+		Sink:              c.String("sink"),
+		Format:            c.String("format"),
+		ExportAddr:        c.String("export-addr"),
+		MonitorInterval:   c.Duration("interval"),
+		GracePeriod:       c.Duration("grace-period"),
+		Runs:              c.Int("runs"),
+		Reprovides:        c.Int("reprovides"),
+		ReprovideInterval: c.Duration("reprovide-interval"),
+		Retrieve:          c.Bool("retrieve"),
+		RetrievalInterval: c.Duration("retrieval-interval"),
+		BatchSize:         c.Int("batch-size"),
+		Concurrency:       c.Int("concurrency"),
+		Simulate:          c.Bool("simulate"),
+		SimNodes:          c.Int("sim-nodes"),
+		SimProfile:        c.String("sim-profile"),
+		ContentPath:       c.String("content-path"),
+		CIDList:           c.String("cid-list"),
+		CIDFormat:         DefaultCIDFormat,
+		ProviderCPL:       -1,
+		RequesterCPL:      -1,
+		StateDir:          c.String("state-dir"),
+		WarmupPeers:       c.Int("warmup-peers"),
+		WarmupBuckets:     c.Int("warmup-buckets"),
+		WarmupTimeout:     c.Duration("warmup-timeout"),
+		GeoIPCityDB:       c.String("geoip-city-db"),
+		GeoIPASNDB:        c.String("geoip-asn-db"),
+	}
+
+	keyType, err := parseKeyType(c.String("key-type"))
+	if err != nil {
+		return nil, err
+	}
+	conf.KeyType = keyType
+
+	if conf.Transports, err = parseTransports(c.StringSlice("transports")); err != nil {
+		return nil, err
+	}
+
+	for _, cpl := range []struct {
+		name  string
+		value *int
+	}{
+		{"provider-cpl", &conf.ProviderCPL},
+		{"requester-cpl", &conf.RequesterCPL},
+	} {
+		if !c.IsSet(cpl.name) {
+			continue
+		}
+		*cpl.value = c.Int(cpl.name)
+		if *cpl.value < 0 || *cpl.value > MaxGrindCPL {
+			return nil, fmt.Errorf("--%s must be between 0 and %d", cpl.name, MaxGrindCPL)
+		}
+		if conf.StateDir != "" {
+			return nil, fmt.Errorf("--%s can't be combined with --state-dir", cpl.name)
+		}
+	}
+
+	if conf.StateDir != "" {
+		if err = os.MkdirAll(conf.StateDir, 0o700); err != nil {
+			return nil, errors.Wrap(err, "create state directory")
+		}
+	}
+
+	if c.IsSet("content-seed") {
+		seed := c.Int64("content-seed")
+		conf.ContentSeed = &seed
+	}
+
+	sources := 0
+	for _, set := range []bool{conf.ContentPath != "", conf.CIDList != "", conf.ContentSeed != nil} {
+		if set {
+			sources += 1
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of --content-path, --cid-list and --content-seed can be set")
+	}
+
+	if c.IsSet("cid-version") || c.IsSet("codec") || c.IsSet("hash") {
+		format, err := NewCIDFormat(c.Uint64("cid-version"), c.String("codec"), c.String("hash"))
+		if err != nil {
+			return nil, errors.Wrap(err, "cid format")
+		}
+		conf.CIDFormat = format
+	}
+
+	switch conf.Format {
+	case FormatCSV, FormatJSONL, FormatParquet:
+	default:
+		return nil, fmt.Errorf("unknown events format %q", conf.Format)
+	}
+
+	if c.IsSet("concurrency") && conf.Concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1")
+	}
+
+	if c.IsSet("bootstrap-peers") {
+		peers, err := parseAddrInfos(c.StringSlice("bootstrap-peers"))
+		if err != nil {
+			return nil, errors.Wrap(err, "parse bootstrap peers")
+		}
+		conf.BootstrapPeers = peers
+	}
+
+	return conf, nil
+}
+
+// parseAddrInfos parses the given p2p multi addresses and groups them by peer ID.
+func parseAddrInfos(addrs []string) ([]peer.AddrInfo, error) {
+	maddrs := make([]ma.Multiaddr, len(addrs))
+	for i, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse multiaddr %s", addr)
+		}
+		maddrs[i] = maddr
+	}
+	return peer.AddrInfosFromP2pAddrs(maddrs...)
+}
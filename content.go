@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"crypto/rand"
-	"crypto/sha256"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/ipfs/go-cid"
 	mh "github.com/multiformats/go-multihash"
 	"github.com/pkg/errors"
 )
 
+// ErrNoMoreContent is returned by content sources that are exhausted.
+var ErrNoMoreContent = errors.New("no more content")
+
 // Content encapsulates multiple representations of the same data.
 type Content struct {
 	raw   []byte
@@ -16,24 +26,228 @@ type Content struct {
 	cid   cid.Cid
 }
 
-// NewRandomContent reads 1024 bytes from crypto/rand and builds a content struct.
-func NewRandomContent() (*Content, error) {
-	raw := make([]byte, 1024)
-	_, err := rand.Read(raw)
-	if err != nil {
-		return nil, errors.Wrap(err, "read rand data")
+// CIDFormat describes how the CID of some data is built.
+type CIDFormat struct {
+	Version  uint64
+	Codec    uint64
+	HashFunc uint64
+}
+
+// DefaultCIDFormat builds CIDv0s, i.e. sha2-256 multihashes of dag-pb data.
+var DefaultCIDFormat = CIDFormat{
+	Version:  0,
+	Codec:    cid.DagProtobuf,
+	HashFunc: mh.SHA2_256,
+}
+
+// codecAliases maps the names of the multicodec table to the codecs
+// that go-cid knows under different names, e.g. protobuf for dag-pb.
+var codecAliases = map[string]uint64{
+	"dag-pb":   cid.DagProtobuf,
+	"dag-cbor": cid.DagCBOR,
+}
+
+// NewCIDFormat looks up the given codec and hash function names
+// and validates that they can be used with the given CID version.
+func NewCIDFormat(version uint64, codec string, hashFunc string) (CIDFormat, error) {
+	cf := CIDFormat{Version: version}
+
+	var found bool
+	if cf.Codec, found = codecAliases[codec]; !found {
+		if cf.Codec, found = cid.Codecs[codec]; !found {
+			return cf, fmt.Errorf("unknown codec %q", codec)
+		}
+	}
+	if cf.HashFunc, found = mh.Names[hashFunc]; !found {
+		return cf, fmt.Errorf("unknown hash function %q", hashFunc)
 	}
-	hash := sha256.New()
-	hash.Write(raw)
 
-	mhash, err := mh.Encode(hash.Sum(nil), mh.SHA2_256)
+	switch version {
+	case 0:
+		if cf.Codec != cid.DagProtobuf || cf.HashFunc != mh.SHA2_256 {
+			return cf, fmt.Errorf("cid version 0 only supports the dag-pb codec and sha2-256")
+		}
+	case 1:
+	default:
+		return cf, fmt.Errorf("unknown cid version %d", version)
+	}
+
+	return cf, nil
+}
+
+// NewContent hashes the given data and builds a content struct
+// whose CID has the given format.
+func NewContent(raw []byte, format CIDFormat) (*Content, error) {
+	mhash, err := mh.Sum(raw, format.HashFunc, -1)
 	if err != nil {
-		return nil, errors.Wrap(err, "encode multi hash")
+		return nil, errors.Wrap(err, "sum multi hash")
+	}
+
+	c := cid.NewCidV1(format.Codec, mhash)
+	if format.Version == 0 {
+		c = cid.NewCidV0(mhash)
 	}
 
 	return &Content{
 		raw:   raw,
 		mhash: mhash,
-		cid:   cid.NewCidV0(mhash),
+		cid:   c,
 	}, nil
 }
+
+// NewRandomContent reads 1024 bytes from crypto/rand and builds a content struct.
+func NewRandomContent() (*Content, error) {
+	return newContentFromReader(rand.Reader, DefaultCIDFormat)
+}
+
+// newContentFromReader reads 1024 bytes from the given reader
+// and builds a content struct with the given CID format.
+func newContentFromReader(r io.Reader, format CIDFormat) (*Content, error) {
+	raw := make([]byte, 1024)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, errors.Wrap(err, "read rand data")
+	}
+	return NewContent(raw, format)
+}
+
+// NewContentFromCID builds a content struct for data that was announced
+// by somebody else. The raw bytes are unknown in this case.
+func NewContentFromCID(c cid.Cid) *Content {
+	return &Content{
+		mhash: c.Hash(),
+		cid:   c,
+	}
+}
+
+type contentKey struct{}
+
+// WithContent returns a context that tags all events that are
+// dispatched on behalf of operations with this context with the
+// CID of the given content.
+func WithContent(ctx context.Context, content *Content) context.Context {
+	return context.WithValue(ctx, contentKey{}, content)
+}
+
+// ContentIDFromContext returns the CID of the content that the
+// given context was tagged with or cid.Undef if it wasn't tagged.
+func ContentIDFromContext(ctx context.Context) cid.Cid {
+	if content, ok := ctx.Value(contentKey{}).(*Content); ok {
+		return content.cid
+	}
+	return cid.Undef
+}
+
+// ContentSource provides the contents that are measured.
+type ContentSource interface {
+	// Next returns the next content or ErrNoMoreContent
+	// if the source is exhausted.
+	Next() (*Content, error)
+}
+
+// NewContentSourceFromConfig initializes the content source that is
+// configured by the user. By default, random content is generated.
+func NewContentSourceFromConfig(conf *Config) (ContentSource, error) {
+	switch {
+	case conf.ContentPath != "":
+		return NewPathContentSource(conf.ContentPath, conf.CIDFormat)
+	case conf.CIDList != "":
+		return NewCIDListContentSource(conf.CIDList)
+	case conf.ContentSeed != nil:
+		return NewSeededContentSource(*conf.ContentSeed, conf.CIDFormat), nil
+	default:
+		return NewRandomContentSource(conf.CIDFormat), nil
+	}
+}
+
+// randomContentSource generates an infinite number of contents
+// by reading random bytes from the given reader.
+type randomContentSource struct {
+	r      io.Reader
+	format CIDFormat
+}
+
+// NewRandomContentSource generates contents from crypto/rand.
+func NewRandomContentSource(format CIDFormat) ContentSource {
+	return &randomContentSource{r: rand.Reader, format: format}
+}
+
+// NewSeededContentSource generates the same sequence of
+// contents for the same seed, so runs can be reproduced.
+func NewSeededContentSource(seed int64, format CIDFormat) ContentSource {
+	return &randomContentSource{r: mrand.New(mrand.NewSource(seed)), format: format}
+}
+
+func (rcs *randomContentSource) Next() (*Content, error) {
+	return newContentFromReader(rcs.r, rcs.format)
+}
+
+// listContentSource returns the given contents in order.
+type listContentSource struct {
+	contents []*Content
+}
+
+// NewPathContentSource builds a content of the given file or of
+// every regular file below the given directory. The CIDs are
+// computed over the raw file data, i.e. they are not the CIDs of
+// the UnixFS DAGs that `ipfs add` would produce.
+func NewPathContentSource(path string, format CIDFormat) (ContentSource, error) {
+	lcs := &listContentSource{}
+	err := filepath.Walk(path, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		raw, err := os.ReadFile(filename)
+		if err != nil {
+			return errors.Wrap(err, "read content file")
+		}
+
+		content, err := NewContent(raw, format)
+		if err != nil {
+			return errors.Wrapf(err, "new content of %s", filename)
+		}
+		lcs.contents = append(lcs.contents, content)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lcs, nil
+}
+
+// NewCIDListContentSource reads the given file that contains one CID per
+// line. Empty lines and lines starting with # are skipped.
+func NewCIDListContentSource(filename string) (ContentSource, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "open cid list")
+	}
+	defer f.Close()
+
+	lcs := &listContentSource{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		c, err := cid.Decode(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode cid %s", line)
+		}
+		lcs.contents = append(lcs.contents, NewContentFromCID(c))
+	}
+
+	return lcs, errors.Wrap(scanner.Err(), "read cid list")
+}
+
+func (lcs *listContentSource) Next() (*Content, error) {
+	if len(lcs.contents) == 0 {
+		return nil, ErrNoMoreContent
+	}
+	content := lcs.contents[0]
+	lcs.contents = lcs.contents[1:]
+	return content, nil
+}
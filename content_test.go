@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestNewCIDFormat(t *testing.T) {
+	tests := []struct {
+		version  uint64
+		codec    string
+		hashFunc string
+		want     CIDFormat
+		wantErr  bool
+	}{
+		{version: 0, codec: "dag-pb", hashFunc: "sha2-256", want: DefaultCIDFormat},
+		{version: 1, codec: "raw", hashFunc: "blake2b-256", want: CIDFormat{Version: 1, Codec: cid.Raw, HashFunc: mh.BLAKE2B_MIN + 31}},
+		{version: 1, codec: "dag-cbor", hashFunc: "sha2-256", want: CIDFormat{Version: 1, Codec: cid.DagCBOR, HashFunc: mh.SHA2_256}},
+		{version: 0, codec: "raw", hashFunc: "sha2-256", wantErr: true},
+		{version: 0, codec: "dag-pb", hashFunc: "sha3-256", wantErr: true},
+		{version: 2, codec: "raw", hashFunc: "sha2-256", wantErr: true},
+		{version: 1, codec: "unknown", hashFunc: "sha2-256", wantErr: true},
+		{version: 1, codec: "raw", hashFunc: "unknown", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := NewCIDFormat(tt.version, tt.codec, tt.hashFunc)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewCIDFormat(%d, %s, %s) succeeded, want error", tt.version, tt.codec, tt.hashFunc)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("NewCIDFormat(%d, %s, %s): %s", tt.version, tt.codec, tt.hashFunc, err)
+		} else if got != tt.want {
+			t.Errorf("NewCIDFormat(%d, %s, %s) = %+v, want %+v", tt.version, tt.codec, tt.hashFunc, got, tt.want)
+		}
+	}
+}
+
+func TestNewContent(t *testing.T) {
+	cf, err := NewCIDFormat(1, "raw", "sha2-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := NewContent([]byte("hello"), cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := content.cid.Prefix()
+	if prefix.Version != 1 || prefix.Codec != cid.Raw || prefix.MhType != mh.SHA2_256 {
+		t.Errorf("prefix = %+v, want CIDv1 raw sha2-256", prefix)
+	}
+	if string(content.mhash) != string(content.cid.Hash()) {
+		t.Errorf("multihash doesn't match the CID")
+	}
+}
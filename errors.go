@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	"github.com/multiformats/go-multistream"
+	"github.com/pkg/errors"
+)
+
+// ErrorClass is a stable category of the errors that are recorded with
+// the events, so that failure reasons can be aggregated across runs.
+type ErrorClass string
+
+const (
+	ErrorClassNone                ErrorClass = ""
+	ErrorClassTimeout             ErrorClass = "timeout"
+	ErrorClassReadTimeout         ErrorClass = "read_timeout"
+	ErrorClassContextCanceled     ErrorClass = "context_canceled"
+	ErrorClassConnectionRefused   ErrorClass = "connection_refused"
+	ErrorClassConnectionReset     ErrorClass = "connection_reset"
+	ErrorClassNoRoute             ErrorClass = "no_route"
+	ErrorClassNoAddresses         ErrorClass = "no_addresses"
+	ErrorClassDialBackoff         ErrorClass = "dial_backoff"
+	ErrorClassPeerIDMismatch      ErrorClass = "peer_id_mismatch"
+	ErrorClassProtocolNegotiation ErrorClass = "protocol_negotiation"
+	ErrorClassStreamReset         ErrorClass = "stream_reset"
+	ErrorClassConnectionClosed    ErrorClass = "connection_closed"
+	ErrorClassNotFound            ErrorClass = "not_found"
+	ErrorClassOther               ErrorClass = "other"
+)
+
+// errorMessageClasses maps substrings of error messages to their class.
+// They are checked in order if the error can't be classified by its type,
+// e.g. because it was read back from an events file. More specific
+// messages must come first.
+var errorMessageClasses = []struct {
+	substr string
+	class  ErrorClass
+}{
+	{"timed out reading response", ErrorClassReadTimeout},
+	{"context canceled", ErrorClassContextCanceled},
+	{"dial backoff", ErrorClassDialBackoff},
+	{"no good addresses", ErrorClassNoAddresses},
+	{"no addresses", ErrorClassNoAddresses},
+	{"peer id mismatch", ErrorClassPeerIDMismatch},
+	{"protocol not supported", ErrorClassProtocolNegotiation},
+	{"failed to negotiate", ErrorClassProtocolNegotiation},
+	{"stream reset", ErrorClassStreamReset},
+	{"connection refused", ErrorClassConnectionRefused},
+	{"connection reset", ErrorClassConnectionReset},
+	{"no route to host", ErrorClassNoRoute},
+	{"network is unreachable", ErrorClassNoRoute},
+	{"host is unreachable", ErrorClassNoRoute},
+	{"deadline exceeded", ErrorClassTimeout},
+	{"i/o timeout", ErrorClassTimeout},
+	{"timed out", ErrorClassTimeout},
+	{"timeout", ErrorClassTimeout},
+	{"connection closed", ErrorClassConnectionClosed},
+	{"use of closed network connection", ErrorClassConnectionClosed},
+	{"EOF", ErrorClassConnectionClosed},
+}
+
+// ClassifyError returns the class of the given error. It returns
+// ErrorClassNone for nil errors and ErrorClassOther if the error
+// doesn't fall into any known class.
+func ClassifyError(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.Is(err, ErrNoProviderRecord):
+		return ErrorClassNotFound
+	case errors.Is(err, ErrReadTimeout):
+		return ErrorClassReadTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorClassContextCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, swarm.ErrDialTimeout):
+		return ErrorClassTimeout
+	case errors.Is(err, swarm.ErrDialBackoff):
+		return ErrorClassDialBackoff
+	case errors.Is(err, swarm.ErrNoAddresses), errors.Is(err, swarm.ErrNoGoodAddresses):
+		return ErrorClassNoAddresses
+	case errors.Is(err, multistream.ErrNotSupported):
+		return ErrorClassProtocolNegotiation
+	case errors.Is(err, mux.ErrReset):
+		return ErrorClassStreamReset
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET):
+		return ErrorClassConnectionReset
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ErrorClassNoRoute
+	case errors.Is(err, swarm.ErrConnClosed), errors.Is(err, net.ErrClosed):
+		return ErrorClassConnectionClosed
+	}
+
+	// The swarm wraps the errors of the individual dials into a
+	// DialError whose cause is only the generic ErrAllDialsFailed.
+	var dialErr *swarm.DialError
+	if errors.As(err, &dialErr) && len(dialErr.DialErrors) > 0 {
+		return ClassifyError(dialErr.DialErrors[0].Cause)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTimeout
+	}
+
+	return ClassifyErrorMessage(err.Error())
+}
+
+// ClassifyErrorMessage returns the class of an error from its message alone.
+// It returns ErrorClassNone for empty messages.
+func ClassifyErrorMessage(msg string) ErrorClass {
+	if msg == "" {
+		return ErrorClassNone
+	} else if msg == ErrNoProviderRecord.Error() {
+		return ErrorClassNotFound
+	}
+
+	for _, emc := range errorMessageClasses {
+		if strings.Contains(msg, emc.substr) {
+			return emc.class
+		}
+	}
+
+	return ErrorClassOther
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	"github.com/multiformats/go-multistream"
+	"github.com/pkg/errors"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{name: "nil", err: nil, want: ErrorClassNone},
+		{name: "no provider record", err: ErrNoProviderRecord, want: ErrorClassNotFound},
+		{name: "read timeout", err: errors.Wrap(ErrReadTimeout, "send request"), want: ErrorClassReadTimeout},
+		{name: "canceled", err: context.Canceled, want: ErrorClassContextCanceled},
+		{name: "deadline", err: fmt.Errorf("dial: %w", context.DeadlineExceeded), want: ErrorClassTimeout},
+		{name: "dial timeout", err: swarm.ErrDialTimeout, want: ErrorClassTimeout},
+		{name: "dial backoff", err: swarm.ErrDialBackoff, want: ErrorClassDialBackoff},
+		{name: "no addresses", err: swarm.ErrNoAddresses, want: ErrorClassNoAddresses},
+		{name: "protocol", err: multistream.ErrNotSupported, want: ErrorClassProtocolNegotiation},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, want: ErrorClassConnectionRefused},
+		{name: "reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: ErrorClassConnectionReset},
+		{name: "unreachable", err: &net.OpError{Op: "dial", Err: syscall.EHOSTUNREACH}, want: ErrorClassNoRoute},
+		{name: "closed", err: net.ErrClosed, want: ErrorClassConnectionClosed},
+		{
+			name: "dial error",
+			err: &swarm.DialError{
+				Cause:      swarm.ErrAllDialsFailed,
+				DialErrors: []swarm.TransportError{{Cause: syscall.ECONNREFUSED}},
+			},
+			want: ErrorClassConnectionRefused,
+		},
+		{name: "message", err: errors.New("failed to negotiate security protocol"), want: ErrorClassProtocolNegotiation},
+		{name: "unknown", err: errors.New("something else"), want: ErrorClassOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyErrorMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want ErrorClass
+	}{
+		{msg: "", want: ErrorClassNone},
+		{msg: "not found", want: ErrorClassNotFound},
+		{msg: "timed out reading response", want: ErrorClassReadTimeout},
+		{msg: "failed to dial: dial backoff", want: ErrorClassDialBackoff},
+		{msg: "dial tcp 1.2.3.4:4001: connect: connection refused", want: ErrorClassConnectionRefused},
+		{msg: "context deadline exceeded", want: ErrorClassTimeout},
+		{msg: "stream reset", want: ErrorClassStreamReset},
+		{msg: "EOF", want: ErrorClassConnectionClosed},
+		{msg: "something else", want: ErrorClassOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := ClassifyErrorMessage(tt.msg); got != tt.want {
+				t.Errorf("ClassifyErrorMessage(%q) = %q, want %q", tt.msg, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"time"
 
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
@@ -14,6 +15,8 @@ import (
 type Event interface {
 	PeerID() peer.ID
 	TimeStamp() time.Time
+	ContentID() cid.Cid
+	OperationID() string
 	Error() error
 }
 
@@ -23,6 +26,14 @@ type Event interface {
 type BaseEvent struct {
 	ID   peer.ID
 	Time time.Time
+
+	// CID is the content the event belongs to. It is cid.Undef
+	// if the event can't be attributed to any content.
+	CID cid.Cid
+
+	// Operation is the ID of the operation the event belongs to. It
+	// is empty if the event can't be attributed to any operation.
+	Operation string
 }
 
 func (e *BaseEvent) PeerID() peer.ID {
@@ -33,10 +44,29 @@ func (e *BaseEvent) TimeStamp() time.Time {
 	return e.Time
 }
 
+func (e *BaseEvent) ContentID() cid.Cid {
+	return e.CID
+}
+
+func (e *BaseEvent) OperationID() string {
+	return e.Operation
+}
+
 func (e *BaseEvent) Error() error {
 	return nil
 }
 
+// attribute tags the event with the given content and operation.
+func (e *BaseEvent) attribute(c cid.Cid, operation string) {
+	e.CID = c
+	e.Operation = operation
+}
+
+// attributable is implemented by all events that embed a BaseEvent.
+type attributable interface {
+	attribute(c cid.Cid, operation string)
+}
+
 // The DialStart event is dispatch when the TCP or Websocket
 // transport modules start dialing a certain peer under the
 // given multi address.
@@ -60,6 +90,61 @@ func (e *DialEnd) Error() error {
 	return e.Err
 }
 
+// The ConnectEnd event is dispatched when the raw socket of an
+// outbound connection is established and the connection upgrade
+// starts. It is followed by the security handshake.
+type ConnectEnd struct {
+	BaseEvent
+	Transport string
+	Maddr     ma.Multiaddr
+}
+
+// The SecurityStart event is dispatched when the security
+// protocol of an outbound connection is negotiated.
+type SecurityStart struct {
+	BaseEvent
+	Transport string
+	Maddr     ma.Multiaddr
+}
+
+// The SecurityEnd event is dispatched when the security handshake
+// of an outbound connection has finished. Protocol is the negotiated
+// security protocol, e.g. /noise.
+type SecurityEnd struct {
+	BaseEvent
+	Transport string
+	Maddr     ma.Multiaddr
+	Protocol  string
+	Err       error
+}
+
+func (e *SecurityEnd) Error() error {
+	return e.Err
+}
+
+// The MuxerStart event is dispatched when the stream multiplexer
+// of a secured outbound connection is negotiated.
+type MuxerStart struct {
+	BaseEvent
+	Transport string
+	Maddr     ma.Multiaddr
+}
+
+// The MuxerEnd event is dispatched when the stream multiplexer
+// negotiation has finished. Protocol is the negotiated stream
+// multiplexer, e.g. /yamux/1.0.0.
+type MuxerEnd struct {
+	BaseEvent
+	Transport string
+	Maddr     ma.Multiaddr
+	Protocol  string
+	Err       error
+}
+
+func (e *MuxerEnd) Error() error {
+	return e.Err
+}
+
 type SendRequestStart struct {
 	BaseEvent
 	Request *pb.Message
@@ -143,3 +228,112 @@ type MonitorProviderEnd struct {
 func (e *MonitorProviderEnd) Error() error {
 	return e.Err
 }
+
+// The ProvideStart event is dispatched when the provider starts
+// to announce the content. It marks the beginning of the lookup
+// of the closest peers. All phase events carry the peer ID of
+// the provider itself. Round is 0 for the initial provide
+// and counts the reprovides afterwards.
+type ProvideStart struct {
+	BaseEvent
+	Round int
+}
+
+// The LookupDone event is dispatched when the provider has
+// finished the lookup of the closest peers to the content.
+type LookupDone struct {
+	BaseEvent
+	Round        int
+	ClosestPeers []peer.ID
+	Err          error
+}
+
+func (e *LookupDone) Error() error {
+	return e.Err
+}
+
+// The AddProviderStart event is dispatched when the provider
+// starts to send ADD_PROVIDER messages to the closest peers.
+type AddProviderStart struct {
+	BaseEvent
+	Round int
+}
+
+// The ProvideEnd event is dispatched when all ADD_PROVIDER
+// messages were sent and the provide operation has finished.
+// PeersSent is the number of peers that the ADD_PROVIDER
+// message was successfully sent to. The message isn't
+// acknowledged, so the peers may not have stored the record.
+type ProvideEnd struct {
+	BaseEvent
+	Round     int
+	PeersSent int
+	Err       error
+}
+
+func (e *ProvideEnd) Error() error {
+	return e.Err
+}
+
+// The RetrievalStart event is dispatched when the retriever starts
+// a FindProviders lookup for the content. All retrieval events carry
+// the peer ID of the retriever.
+type RetrievalStart struct {
+	BaseEvent
+	Round int
+}
+
+// The RetrievalQuery event is dispatched when the retriever asks
+// a peer for providers. Hop is the number of steps it took to
+// discover that peer starting from the retriever's routing table.
+type RetrievalQuery struct {
+	BaseEvent
+	Round  int
+	Target peer.ID
+	Hop    int
+}
+
+// The ProviderFound event is dispatched when the FindProviders
+// lookup has returned a provider of the content. Hops is the
+// depth that the lookup had reached at that time.
+type ProviderFound struct {
+	BaseEvent
+	Round    int
+	Provider peer.ID
+	Hops     int
+	Duration time.Duration
+}
+
+// The RetrievalEnd event is dispatched when the FindProviders lookup
+// has terminated.
+type RetrievalEnd struct {
+	BaseEvent
+	Round        int
+	Hops         int
+	PeersQueried int
+	Duration     time.Duration
+	Err          error
+}
+
+func (e *RetrievalEnd) Error() error {
+	return e.Err
+}
+
+// The ClosestPeerJoined event is dispatched when a peer appears
+// among the closest peers to the content while monitoring the
+// persistence of the provider record.
+type ClosestPeerJoined struct {
+	BaseEvent
+}
+
+// The ClosestPeerLeft event is dispatched when a peer is no
+// longer among the closest peers to the content.
+type ClosestPeerLeft struct {
+	BaseEvent
+}
+
+// The RecordDropped event is dispatched when a peer that has
+// returned the provider record before doesn't return it anymore.
+type RecordDropped struct {
+	BaseEvent
+}
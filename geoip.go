@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// GeoIP looks up the location and the autonomous system of IP
+// addresses in local MaxMind databases (e.g. GeoLite2-City and
+// GeoLite2-ASN). Either database may be missing, in which case
+// the respective fields are left empty.
+type GeoIP struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// cityRecord holds the fields of a record of a MaxMind city
+// database that are needed for the peers table.
+type cityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// asnRecord is a record of a MaxMind ASN database.
+type asnRecord struct {
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// GeoInfo is the result of a GeoIP lookup.
+type GeoInfo struct {
+	IP      string
+	Country string
+	City    string
+	ASN     uint
+	ASOrg   string
+}
+
+// NewGeoIP opens the given city and ASN databases. Empty file names are skipped.
+func NewGeoIP(cityFile string, asnFile string) (*GeoIP, error) {
+	geo := &GeoIP{}
+
+	var err error
+	if cityFile != "" {
+		if geo.city, err = maxminddb.Open(cityFile); err != nil {
+			return nil, errors.Wrap(err, "open city database")
+		}
+	}
+
+	if asnFile != "" {
+		if geo.asn, err = maxminddb.Open(asnFile); err != nil {
+			geo.Close()
+			return nil, errors.Wrap(err, "open ASN database")
+		}
+	}
+
+	return geo, nil
+}
+
+// NewGeoIPFromConfig opens the databases that are configured by
+// the user. It returns nil if no database is configured.
+func NewGeoIPFromConfig(conf *Config) (*GeoIP, error) {
+	if conf.GeoIPCityDB == "" && conf.GeoIPASNDB == "" {
+		return nil, nil
+	}
+	return NewGeoIP(conf.GeoIPCityDB, conf.GeoIPASNDB)
+}
+
+// Lookup returns the location and the autonomous system of the IP address
+// of the first of the given multi addresses that holds a public IP address.
+// It returns nil if there is no such address or no database was opened.
+func (geo *GeoIP) Lookup(maddrs ...ma.Multiaddr) *GeoInfo {
+	if geo == nil {
+		return nil
+	}
+
+	var ip net.IP
+	for _, maddr := range maddrs {
+		addrIP, err := manet.ToIP(maddr)
+		if err == nil && manet.IsPublicAddr(maddr) {
+			ip = addrIP
+			break
+		}
+	}
+	if ip == nil {
+		return nil
+	}
+
+	gi := &GeoInfo{IP: ip.String()}
+
+	if geo.city != nil {
+		city := &cityRecord{}
+		if err := geo.city.Lookup(ip, city); err != nil {
+			log.WithError(err).WithField("ip", gi.IP).Debugln("Could not look up city")
+		} else {
+			gi.Country = city.Country.IsoCode
+			gi.City = city.City.Names["en"]
+		}
+	}
+
+	if geo.asn != nil {
+		asn := &asnRecord{}
+		if err := geo.asn.Lookup(ip, asn); err != nil {
+			log.WithError(err).WithField("ip", gi.IP).Debugln("Could not look up ASN")
+		} else {
+			gi.ASN = asn.AutonomousSystemNumber
+			gi.ASOrg = asn.AutonomousSystemOrganization
+		}
+	}
+
+	return gi
+}
+
+// Close closes all opened databases.
+func (geo *GeoIP) Close() {
+	if geo == nil {
+		return
+	}
+	if geo.city != nil {
+		geo.city.Close()
+	}
+	if geo.asn != nil {
+		geo.asn.Close()
+	}
+}
@@ -3,27 +3,33 @@ module github.com/dennis-tra/dht-provide-measurement
 go 1.16
 
 require (
-	bou.ke/monkey v1.0.2
 	contrib.go.opencensus.io/exporter/zipkin v0.1.2
 	github.com/ipfs/go-cid v0.0.7
 	github.com/ipfs/go-ipfs-util v0.0.2
 	github.com/ipfs/go-log v1.0.5
-	github.com/ipfs/go-todocounter v0.0.2 // indirect
 	github.com/libp2p/go-libp2p v0.14.4
 	github.com/libp2p/go-libp2p-core v0.8.6
 	github.com/libp2p/go-libp2p-kad-dht v0.13.1
 	github.com/libp2p/go-libp2p-kbucket v0.4.7
-	github.com/libp2p/go-libp2p-routing v0.1.0 // indirect
+	github.com/libp2p/go-libp2p-quic-transport v0.11.2
+	github.com/libp2p/go-libp2p-swarm v0.5.3
 	github.com/libp2p/go-libp2p-transport-upgrader v0.4.6
 	github.com/libp2p/go-msgio v0.0.6
 	github.com/libp2p/go-tcp-transport v0.2.7
 	github.com/libp2p/go-ws-transport v0.4.0
 	github.com/multiformats/go-multiaddr v0.3.3
 	github.com/multiformats/go-multihash v0.0.15
+	github.com/multiformats/go-multistream v0.2.2
 	github.com/openzipkin/zipkin-go v0.2.2
+	github.com/oschwald/maxminddb-golang v1.3.1
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.6.0
+	github.com/urfave/cli/v2 v2.3.0
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	go.opencensus.io v0.23.0
 	go.uber.org/atomic v1.7.0
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 )
+
+replace github.com/libp2p/go-libp2p-kad-dht => ./third_party/go-libp2p-kad-dht
@@ -2,52 +2,123 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
-	"encoding/hex"
 	"fmt"
-	"os"
-	"strings"
+	"path/filepath"
 	"sync"
 	"time"
 
-	"github.com/libp2p/go-libp2p-core/routing"
-
-	kbucket "github.com/libp2p/go-libp2p-kbucket"
-
-	"github.com/libp2p/go-libp2p-core/protocol"
-
-	u "github.com/ipfs/go-ipfs-util"
-	"go.uber.org/atomic"
-
-	log "github.com/sirupsen/logrus"
-
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
 )
 
+// EventHub collects the events of a measurement and forwards them to
+// all registered sinks as they are pushed. It also keeps track of the
+// peers that are relevant for the measurement, so that sinks can restrict
+// their output to those peers after the hub was stopped.
 type EventHub struct {
-	mutex     sync.RWMutex
-	events    map[peer.ID][]Event
+	mutex     sync.Mutex
+	sinks     []EventSink
 	relevant  sync.Map
+	contacts  sync.Map
 	stopped   *atomic.Bool
+	host      host.Host
 	startTime time.Time
 	stopTime  time.Time
 }
 
-func NewEventHub() *EventHub {
+// NewEventHub initializes a new event hub that forwards all events
+// to the given sinks. If no sink is given, events are kept in memory.
+func NewEventHub(sinks ...EventSink) *EventHub {
+	if len(sinks) == 0 {
+		sinks = []EventSink{NewMemorySink()}
+	}
+
 	return &EventHub{
-		events:   map[peer.ID][]Event{},
+		sinks:    sinks,
 		relevant: sync.Map{},
 		stopped:  atomic.NewBool(false),
 	}
 }
 
+// NewEventHubFromConfig initializes a new event hub with the sinks that
+// are configured by the user. Streamed events are written to dir.
+func NewEventHubFromConfig(conf *Config, content *Content, dir string) (*EventHub, error) {
+	sinks := []EventSink{NewStatsSink()}
+	switch conf.Sink {
+	case "memory":
+		sinks = append(sinks, NewMemorySink())
+	case "file":
+		fs, err := NewFileSink(filepath.Join(dir, "events.stream.jsonl"), content)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, fs)
+	default:
+		return nil, fmt.Errorf("unknown sink %s", conf.Sink)
+	}
+
+	if conf.ExportAddr != "" {
+		ns, err := NewNetworkSink(conf.ExportAddr, content)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, ns)
+	}
+
+	return NewEventHub(sinks...), nil
+}
+
 func (eh *EventHub) MarkAsRelevant(peerID peer.ID) {
 	eh.relevant.Store(peerID, struct{}{})
 }
 
+// Attribute records that the given peer is contacted on behalf of the content
+// and the operation of the given context. The swarm dials with a context of its
+// own, so the transports can't tag the events of the connection setup. Instead,
+// they are attributed to the last operation that contacted the peer. If
+// concurrent operations contact the same peer, its dials are attributed to
+// the one that came last.
+func (eh *EventHub) Attribute(ctx context.Context, peerID peer.ID) {
+	if eh == nil {
+		return
+	}
+	eh.contacts.Store(peerID, &attribution{
+		cid:       ContentIDFromContext(ctx),
+		operation: OperationIDFromContext(ctx),
+	})
+}
+
+// attribution is the content and the operation on behalf of which a peer
+// was contacted last.
+type attribution struct {
+	cid       cid.Cid
+	operation string
+}
+
+// attribute tags the given untagged event with the content and operation
+// that its peer was contacted on behalf of last.
+func (eh *EventHub) attribute(event Event) {
+	if event.OperationID() != "" {
+		return
+	}
+	if a, found := eh.contacts.Load(event.PeerID()); found {
+		event.(attributable).attribute(a.(*attribution).cid, a.(*attribution).operation)
+	}
+}
+
+// IsRelevant returns true if the given peer was marked as relevant.
+func (eh *EventHub) IsRelevant(peerID peer.ID) bool {
+	_, found := eh.relevant.Load(peerID)
+	return found
+}
+
 func (eh *EventHub) PushEvent(event Event) {
 	if eh == nil || eh.stopped.Load() {
 		return
@@ -56,20 +127,36 @@ func (eh *EventHub) PushEvent(event Event) {
 	eh.mutex.Lock()
 	defer eh.mutex.Unlock()
 
-	events, found := eh.events[event.PeerID()]
-	if !found {
-		eh.events[event.PeerID()] = []Event{}
+	// The hub may have been stopped while we were waiting for the lock.
+	if eh.stopped.Load() {
+		return
+	}
+
+	switch event.(type) {
+	case *DialStart, *DialEnd, *ConnectEnd, *SecurityStart, *SecurityEnd, *MuxerStart, *MuxerEnd:
+		eh.attribute(event)
 	}
-	// log.WithField("peerID", event.PeerID().Pretty()[:16]).
-	//	WithField("event", fmt.Sprintf("%T", event)).
-	//	Infoln("Pushing Event")
 
-	eh.events[event.PeerID()] = append(events, event)
+	for _, sink := range eh.sinks {
+		if err := sink.Consume(event); err != nil {
+			log.WithError(err).WithField("sink", fmt.Sprintf("%T", sink)).Warnln("Could not consume event")
+		}
+	}
 }
 
+// Start records the connection and stream notifications of the given host
+// and returns a context that marks the peers of all DHT queries with it as
+// relevant.
 func (eh *EventHub) Start(ctx context.Context, h host.Host) context.Context {
 	eh.startTime = time.Now()
+	eh.host = h
 	h.Network().Notify(eh)
+	return eh.TrackQueries(ctx)
+}
+
+// TrackQueries marks all peers that are involved in DHT queries
+// with the returned context as relevant.
+func (eh *EventHub) TrackQueries(ctx context.Context) context.Context {
 	ctx, queryEvents := routing.RegisterForQueryEvents(ctx)
 	go eh.handleQueryEvents(queryEvents)
 	return ctx
@@ -81,25 +168,30 @@ func (eh *EventHub) handleQueryEvents(queryEvents <-chan *routing.QueryEvent) {
 	}
 }
 
-func (eh *EventHub) Stop(h host.Host) {
-	eh.stopped.Store(true)
+// Stop stops recording events and closes all sinks. It is safe to call
+// Stop multiple times, e.g. deferred on error paths. Only the first call
+// closes the sinks.
+func (eh *EventHub) Stop() error {
+	if !eh.stopped.CAS(false, true) {
+		return nil
+	}
 	eh.stopTime = time.Now()
 
 	eh.mutex.Lock()
 	defer eh.mutex.Unlock()
 
-	h.Network().StopNotify(eh)
+	if eh.host != nil {
+		eh.host.Network().StopNotify(eh)
+	}
+
+	var err error
+	for _, sink := range eh.sinks {
+		if cerr := sink.Close(); cerr != nil && err == nil {
+			err = errors.Wrapf(cerr, "close %T", sink)
+		}
+	}
 
-	// Since we collected all Dials and whatnot we filter the events
-	// for peer IDs that were used for getting the closest peers in
-	// the call to dht.Provide. Those relevant peers are marked as
-	// relevant in the handleQueryEvents method.
-	relevant := map[peer.ID][]Event{}
-	eh.relevant.Range(func(key, value interface{}) bool {
-		relevant[key.(peer.ID)] = eh.events[key.(peer.ID)]
-		return true
-	})
-	eh.events = relevant
+	return err
 }
 
 func (eh *EventHub) Listen(n network.Network, multiaddr multiaddr.Multiaddr) {
@@ -109,12 +201,16 @@ func (eh *EventHub) ListenClose(n network.Network, multiaddr multiaddr.Multiaddr
 }
 
 func (eh *EventHub) Connected(n network.Network, conn network.Conn) {
-	eh.PushEvent(&ConnectedEvent{
+	event := &ConnectedEvent{
 		BaseEvent: BaseEvent{
 			ID:   conn.RemotePeer(),
 			Time: time.Now(),
 		},
-	})
+	}
+	if conn.Stat().Direction == network.DirOutbound {
+		eh.attribute(event)
+	}
+	eh.PushEvent(event)
 }
 
 func (eh *EventHub) Disconnected(n network.Network, conn network.Conn) {
@@ -127,13 +223,17 @@ func (eh *EventHub) Disconnected(n network.Network, conn network.Conn) {
 }
 
 func (eh *EventHub) OpenedStream(n network.Network, stream network.Stream) {
-	eh.PushEvent(&OpenedStream{
+	event := &OpenedStream{
 		BaseEvent: BaseEvent{
 			ID:   stream.Conn().RemotePeer(),
 			Time: time.Now(),
 		},
 		Protocol: stream.Protocol(),
-	})
+	}
+	if stream.Stat().Direction == network.DirOutbound {
+		eh.attribute(event)
+	}
+	eh.PushEvent(event)
 }
 
 func (eh *EventHub) ClosedStream(n network.Network, stream network.Stream) {
@@ -146,68 +246,58 @@ func (eh *EventHub) ClosedStream(n network.Network, stream network.Stream) {
 	})
 }
 
-func (eh *EventHub) Serialize(content *Content) {
-	f, err := os.Create("events.csv")
+// Serialize writes all events of relevant peers to the given file. The
+// format is derived from the file extension (csv, jsonl or parquet).
+// Since we collected all Dials and whatnot we filter the events for peer
+// IDs that were used for getting the closest peers in the call to
+// dht.Provide. Those relevant peers are marked as relevant in the
+// handleQueryEvents method. The first sink that supports writing the
+// relevant events is used.
+func (eh *EventHub) Serialize(content *Content, filename string) error {
+	for _, sink := range eh.sinks {
+		rew, ok := sink.(RelevantEventWriter)
+		if !ok {
+			continue
+		}
+
+		w, err := NewEventRecordWriter(filename)
+		if err != nil {
+			return err
+		}
+
+		if err = rew.WriteRelevant(w, content, eh); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}
+	return fmt.Errorf("no sink can write relevant events")
+}
+
+// SaveSummary writes the summary of the provide operation to the given file.
+func (eh *EventHub) SaveSummary(filename string) error {
+	ss, err := eh.statsSink()
 	if err != nil {
-		log.Fatalln(err)
-	}
-	defer f.Close()
-
-	w := csv.NewWriter(f)
-	defer w.Flush()
-
-	// Write header
-	w.Write([]string{
-		"peer_id",
-		"distance",
-		"time",
-		"type",
-		"has_error",
-		"error",
-		"extra",
-	})
-	for peerID, events := range eh.events {
-		distance := u.XOR(kbucket.ConvertPeerID(peerID), kbucket.ConvertKey(string(content.mhash)))
-
-		for _, evt := range events {
-			extra := ""
-			switch event := evt.(type) {
-			case *DialStart:
-				extra = event.Maddr.String()
-			case *DialEnd:
-				extra = event.Maddr.String()
-			case *OpenStreamStart:
-				extra = strings.Join(protocol.ConvertToStrings(event.Protocols), ",")
-			case *OpenStreamEnd:
-				extra = strings.Join(protocol.ConvertToStrings(event.Protocols), ",")
-			case *OpenedStream:
-				extra = protocol.ConvertToStrings([]protocol.ID{event.Protocol})[0]
-			case *ClosedStream:
-				extra = protocol.ConvertToStrings([]protocol.ID{event.Protocol})[0]
-			case *SendRequestStart:
-				extra = event.Request.Type.String()
-			case *SendRequestEnd:
-				if event.Err == nil {
-					extra = event.Response.Type.String()
-				}
-			case *SendMessageStart:
-				extra = event.Message.Type.String()
-			case *DiscoveredPeer:
-				extra = hex.EncodeToString(u.XOR(kbucket.ConvertPeerID(event.Discovered), kbucket.ConvertKey(string(content.mhash))))
-			}
-			errorStr := ""
-			if evt.Error() != nil {
-				errorStr = strings.ReplaceAll(evt.Error().Error(), "\n", " ")
-			}
-			w.Write([]string{
-				peerID.Pretty(),
-				hex.EncodeToString(distance),
-				fmt.Sprintf("%.6f", evt.TimeStamp().Sub(eh.startTime).Seconds()),
-				fmt.Sprintf("%T", evt),
-				fmt.Sprintf("%t", evt.Error() != nil),
-				errorStr,
-				extra,
-			})
+		return err
+	}
+	return ss.Summary(eh.startTime).Save(filename)
+}
+
+// SaveBatchSummary writes the summary of a batch of provide operations
+// that were performed with the given concurrency to the given file.
+func (eh *EventHub) SaveBatchSummary(filename string, concurrency int) error {
+	ss, err := eh.statsSink()
+	if err != nil {
+		return err
+	}
+	return ss.BatchSummary(eh.startTime, concurrency).Save(filename)
+}
+
+func (eh *EventHub) statsSink() (*StatsSink, error) {
+	for _, sink := range eh.sinks {
+		if ss, ok := sink.(*StatsSink); ok {
+			return ss, nil
 		}
 	}
+	return nil, fmt.Errorf("no stats sink registered")
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func TestEventHubAttribute(t *testing.T) {
+	contacted := test.RandPeerIDFatal(t)
+	other := test.RandPeerIDFatal(t)
+	content, err := NewRandomContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms := NewMemorySink()
+	eh := NewEventHub(ms)
+	eh.Attribute(WithOperation(WithContent(context.Background(), content), "provide"), contacted)
+
+	dial := &DialStart{BaseEvent: BaseEvent{ID: contacted, Time: time.Now()}}
+	otherDial := &DialStart{BaseEvent: BaseEvent{ID: other, Time: time.Now()}}
+	tagged := &DialEnd{BaseEvent: BaseEvent{ID: contacted, Time: time.Now(), Operation: "monitor-1"}}
+	request := &SendRequestStart{BaseEvent: BaseEvent{ID: contacted, Time: time.Now()}}
+	for _, event := range []Event{dial, otherDial, tagged, request} {
+		eh.PushEvent(event)
+	}
+
+	if dial.OperationID() == "" || !dial.ContentID().Equals(content.cid) {
+		t.Errorf("dial of contacted peer tagged with %q/%s, want the provide", dial.OperationID(), dial.ContentID())
+	}
+	if otherDial.OperationID() != "" {
+		t.Errorf("dial of other peer tagged with %q, want none", otherDial.OperationID())
+	}
+	if tagged.OperationID() != "monitor-1" {
+		t.Errorf("tagged dial retagged with %q", tagged.OperationID())
+	}
+	if request.OperationID() != "" {
+		t.Errorf("request tagged with %q, want none", request.OperationID())
+	}
+
+	// A later untagged contact, e.g. of a routing table refresh, resets the attribution.
+	eh.Attribute(context.Background(), contacted)
+	refresh := &DialStart{BaseEvent: BaseEvent{ID: contacted, Time: time.Now()}}
+	eh.PushEvent(refresh)
+	if refresh.OperationID() != "" {
+		t.Errorf("dial after untagged contact tagged with %q, want none", refresh.OperationID())
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+)
+
+// MaxGrindCPL is the largest common prefix length that keys are ground for.
+// The expected number of generated keys doubles with every bit.
+const MaxGrindCPL = 24
+
+// KeyTypes maps the names of the supported key types to their libp2p
+// crypto constants.
+var KeyTypes = map[string]int{
+	"ed25519":   crypto.Ed25519,
+	"rsa":       crypto.RSA,
+	"secp256k1": crypto.Secp256k1,
+}
+
+// generateKey generates a key pair of the given type.
+func generateKey(keyType int) (crypto.PrivKey, error) {
+	bits := 256
+	if keyType == crypto.RSA {
+		bits = 2048
+	}
+	key, _, err := crypto.GenerateKeyPair(keyType, bits)
+	return key, errors.Wrap(err, "generate key pair")
+}
+
+// NewKey generates a new key pair of the given type. If cpl is not negative,
+// key pairs are generated until the derived peer ID shares exactly cpl leading
+// bits with the given content in the DHT key space. This takes about 2^(cpl+1)
+// attempts, which are spread over all CPUs.
+func NewKey(ctx context.Context, keyType int, content *Content, cpl int) (crypto.PrivKey, error) {
+	if cpl < 0 {
+		return generateKey(keyType)
+	}
+
+	if cpl > MaxGrindCPL {
+		return nil, errors.Errorf("common prefix length %d exceeds maximum of %d", cpl, MaxGrindCPL)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	target := kbucket.ConvertKey(string(content.mhash))
+	attempts := atomic.NewUint64(0)
+	found := make(chan crypto.PrivKey, 1)
+	errs := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				key, err := generateKey(keyType)
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					cancel()
+					return
+				}
+				attempts.Inc()
+
+				peerID, err := peer.IDFromPrivateKey(key)
+				if err != nil {
+					continue
+				}
+
+				if kbucket.CommonPrefixLen(kbucket.ConvertPeerID(peerID), target) != cpl {
+					continue
+				}
+
+				select {
+				case found <- key:
+				default:
+				}
+				cancel()
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case key := <-found:
+		log.WithField("cpl", cpl).WithField("attempts", attempts.Load()).Infoln("Found key")
+		return key, nil
+	case err := <-errs:
+		return nil, err
+	default:
+		return nil, ctx.Err()
+	}
+}
+
+// CommonPrefixLen returns the number of leading bits that the
+// given peer shares with the given content in the DHT key space.
+func CommonPrefixLen(peerID peer.ID, content *Content) int {
+	return kbucket.CommonPrefixLen(kbucket.ConvertPeerID(peerID), kbucket.ConvertKey(string(content.mhash)))
+}
+
+// NewKeyFromConfig returns the identity of the host with the given role
+// (e.g. "provider"). If a state directory is configured, the key is loaded
+// from <role>.key in that directory or generated and saved there if the
+// file doesn't exist yet. Otherwise, a new key is generated as in NewKey.
+// A saved key fixes the identity, so it must be of the configured key type.
+// ConfigFromContext rejects a common prefix length with a state directory.
+func NewKeyFromConfig(ctx context.Context, conf *Config, role string, content *Content, cpl int) (crypto.PrivKey, error) {
+	if conf.StateDir == "" {
+		return NewKey(ctx, conf.KeyType, content, cpl)
+	}
+
+	filename := StateFilename(conf, role, "key")
+	data, err := os.ReadFile(filename)
+	if err == nil {
+		key, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unmarshal key %s", filename)
+		}
+		if int(key.Type()) != conf.KeyType {
+			return nil, errors.Errorf("key %s is of type %s, but %s was requested", filename, key.Type(), keyTypeName(conf.KeyType))
+		}
+		log.WithField("role", role).WithField("file", filename).Infoln("Loaded key")
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "read key file")
+	}
+
+	key, err := NewKey(ctx, conf.KeyType, content, cpl)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal key")
+	}
+
+	if err = os.WriteFile(filename, data, 0o600); err != nil {
+		return nil, errors.Wrap(err, "write key file")
+	}
+	log.WithField("role", role).WithField("file", filename).Infoln("Saved key")
+
+	return key, nil
+}
+
+// keyTypeName returns the name of the given libp2p crypto constant.
+func keyTypeName(keyType int) string {
+	for name, kt := range KeyTypes {
+		if kt == keyType {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d", keyType)
+}
+
+// parseKeyType looks up the libp2p crypto constant of the given key type name.
+func parseKeyType(name string) (int, error) {
+	keyType, found := KeyTypes[name]
+	if !found {
+		return 0, fmt.Errorf("unknown key type %q", name)
+	}
+	return keyType, nil
+}
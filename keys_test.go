@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestNewKey(t *testing.T) {
+	content, err := NewRandomContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cpl := range []int{0, 1, 4, 8} {
+		key, err := NewKey(context.Background(), crypto.Ed25519, content, cpl)
+		if err != nil {
+			t.Fatalf("NewKey(cpl=%d): %s", cpl, err)
+		}
+
+		peerID, err := peer.IDFromPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := CommonPrefixLen(peerID, content); got != cpl {
+			t.Errorf("NewKey(cpl=%d) generated peer with common prefix length %d", cpl, got)
+		}
+	}
+
+	if _, err = NewKey(context.Background(), crypto.Ed25519, content, MaxGrindCPL+1); err == nil {
+		t.Errorf("expected error for common prefix length above %d", MaxGrindCPL)
+	}
+}
+
+func TestNewKeyFromConfig(t *testing.T) {
+	ctx := context.Background()
+	conf := &Config{StateDir: t.TempDir(), KeyType: crypto.Ed25519}
+
+	saved, err := NewKeyFromConfig(ctx, conf, "provider", nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewKeyFromConfig(ctx, conf, "provider", nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Equals(loaded) {
+		t.Errorf("loaded key differs from saved key")
+	}
+
+	conf.KeyType = crypto.Secp256k1
+	if _, err = NewKeyFromConfig(ctx, conf, "provider", nil, -1); err == nil {
+		t.Errorf("expected error for saved key of other type")
+	}
+}
+
+func TestParseKeyType(t *testing.T) {
+	for name, want := range KeyTypes {
+		got, err := parseKeyType(name)
+		if err != nil {
+			t.Errorf("parseKeyType(%q): %s", name, err)
+		} else if got != want {
+			t.Errorf("parseKeyType(%q) = %d, want %d", name, got, want)
+		}
+
+		if keyTypeName(want) != name {
+			t.Errorf("keyTypeName(%d) = %q, want %q", want, keyTypeName(want), name)
+		}
+	}
+
+	if _, err := parseKeyType("dsa"); err == nil {
+		t.Errorf("expected error for unknown key type")
+	}
+}
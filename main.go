@@ -5,77 +5,219 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-
-	"golang.org/x/sync/errgroup"
+	"time"
 
 	"contrib.go.opencensus.io/exporter/zipkin"
 	openzipkin "github.com/openzipkin/zipkin-go"
 	zipkinHTTP "github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
 	"go.opencensus.io/trace"
 )
 
 func main() {
-	ctx := context.Background()
+	app := newApp()
 
-	// Generate random content that we'll provide in the DHT.
-	content, err := NewRandomContent()
-	if err != nil {
-		log.Fatalln(errors.Wrap(err, "new random content"))
-	}
-	log.WithField("cid", content.cid.String()).Infof("Generated content")
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
-	eh := NewEventHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sig := <-sigs
+		log.Infof("Received %s - stopping", sig)
+		cancel()
+	}()
 
-	// Construct the requester (must come before new provider due to monkey patching)
-	requester, err := NewRequester(ctx, eh)
-	if err != nil {
-		log.Fatalln(errors.Wrap(err, "new requester"))
+	if err := app.RunContext(ctx, os.Args); err != nil {
+		log.Fatalln(err)
 	}
+	log.Infoln("Exiting")
+}
 
-	// Construct the provider libp2p host
-	provider, err := NewProvider(ctx, eh)
-	if err != nil {
-		log.Fatalln(errors.Wrap(err, "new provider"))
+// newApp constructs the command line application with all its commands.
+func newApp() *cli.App {
+	return &cli.App{
+		Name:  "dht-provide-measurement",
+		Usage: "Measures the performance of provide operations in the IPFS DHT",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:        "bootstrap-peers",
+				Usage:       "Comma separated list of multi addresses of bootstrap peers",
+				EnvVars:     []string{"DPM_BOOTSTRAP_PEERS"},
+				DefaultText: "default IPFS",
+			},
+			&cli.StringFlag{
+				Name:    "out",
+				Usage:   "Directory where the measurement output is written to",
+				EnvVars: []string{"DPM_OUT"},
+				Value:   ".",
+			},
+			&cli.StringFlag{
+				Name:    "sink",
+				Usage:   "Where events are collected during a measurement (memory, file). The file sink doesn't keep the events in memory",
+				EnvVars: []string{"DPM_SINK"},
+				Value:   "memory",
+			},
+			&cli.StringFlag{
+				Name:    "format",
+				Usage:   "Serialization format of the events file (csv, jsonl, parquet)",
+				EnvVars: []string{"DPM_FORMAT"},
+				Value:   FormatCSV,
+			},
+			&cli.StringFlag{
+				Name:    "key-type",
+				Usage:   "The type of the generated host keys (ed25519, rsa, secp256k1)",
+				EnvVars: []string{"DPM_KEY_TYPE"},
+				Value:   "secp256k1",
+			},
+			&cli.StringSliceFlag{
+				Name:        "transports",
+				Usage:       "Comma separated list of the transports the provider dials with (tcp, ws, quic)",
+				EnvVars:     []string{"DPM_TRANSPORTS"},
+				DefaultText: "tcp,ws",
+			},
+			&cli.StringFlag{
+				Name:    "state-dir",
+				Usage:   "Directory in which the host keys, routing tables and peerstores are kept between runs",
+				EnvVars: []string{"DPM_STATE_DIR"},
+			},
+			&cli.StringFlag{
+				Name:    "export-addr",
+				Usage:   "TCP address to which all events are streamed as JSON lines while they are recorded",
+				EnvVars: []string{"DPM_EXPORT_ADDR"},
+			},
+			&cli.StringFlag{
+				Name:    "geoip-city-db",
+				Usage:   "MaxMind city database (.mmdb) used to locate the relevant peers in the peers table",
+				EnvVars: []string{"DPM_GEOIP_CITY_DB"},
+			},
+			&cli.StringFlag{
+				Name:    "geoip-asn-db",
+				Usage:   "MaxMind ASN database (.mmdb) used to look up the autonomous systems of the relevant peers",
+				EnvVars: []string{"DPM_GEOIP_ASN_DB"},
+			},
+		},
+		Commands: []*cli.Command{
+			MeasureCommand,
+			MonitorOnlyCommand,
+			ProvideOnlyCommand,
+			ProvideBatchCommand,
+			PersistenceCommand,
+			AnalyzeCommand,
+		},
 	}
+}
 
-	// Bootstrap both libp2p hosts by connecting the canonical bootstrap peers.
-	group, ctx := errgroup.WithContext(ctx)
-	group.Go(func() error {
-		return provider.Bootstrap(ctx)
-	})
-	group.Go(func() error {
-		return requester.Bootstrap(ctx)
-	})
-	if err = group.Wait(); err != nil {
-		log.Fatalln(errors.Wrap(err, "bootstrap err group"))
+// The following flags are shared between multiple commands.
+var (
+	intervalFlag = &cli.DurationFlag{
+		Name:    "interval",
+		Usage:   "How often the closest peers are asked for provider records",
+		EnvVars: []string{"DPM_INTERVAL"},
+		Value:   500 * time.Millisecond,
 	}
-
-	// Start pinging the closest peers to the random content from above for provider records.
-	if err = requester.MonitorProviders(context.Background(), content); err != nil {
-		log.Fatalln(errors.Wrap(err, "monitor provider"))
+	gracePeriodFlag = &cli.DurationFlag{
+		Name:    "grace-period",
+		Usage:   "How long to keep recording events after the provide operation has finished",
+		EnvVars: []string{"DPM_GRACE_PERIOD"},
+		Value:   5 * time.Second,
 	}
-
-	// Provide the random content from above.
-	if err = provider.Provide(context.Background(), content); err != nil {
-		log.Fatalln(errors.Wrap(err, "provide"))
+	reprovidesFlag = &cli.IntFlag{
+		Name:    "reprovides",
+		Usage:   "How often the content is provided again after the initial provide",
+		EnvVars: []string{"DPM_REPROVIDES"},
 	}
+	reprovideIntervalFlag = &cli.DurationFlag{
+		Name:    "reprovide-interval",
+		Usage:   "How long to wait before each reprovide",
+		EnvVars: []string{"DPM_REPROVIDE_INTERVAL"},
+		Value:   time.Minute,
+	}
+	simulateFlag = &cli.BoolFlag{
+		Name:    "simulate",
+		Usage:   "Measure against an in-process simulated DHT network instead of the live IPFS network",
+		EnvVars: []string{"DPM_SIMULATE"},
+	}
+	simNodesFlag = &cli.IntFlag{
+		Name:    "sim-nodes",
+		Usage:   "The number of DHT server nodes in the simulated network",
+		EnvVars: []string{"DPM_SIM_NODES"},
+		Value:   200,
+	}
+	simProfileFlag = &cli.StringFlag{
+		Name:    "sim-profile",
+		Usage:   "JSON file that assigns latency, loss and bandwidth to the simulated peers",
+		EnvVars: []string{"DPM_SIM_PROFILE"},
+	}
+	warmupPeersFlag = &cli.IntFlag{
+		Name:    "warmup-peers",
+		Usage:   "Refresh the routing table of the provider until it holds this many peers before measuring",
+		EnvVars: []string{"DPM_WARMUP_PEERS"},
+	}
+	warmupBucketsFlag = &cli.IntFlag{
+		Name:    "warmup-buckets",
+		Usage:   "Refresh the routing table of the provider until this many buckets (starting at the farthest) are full before measuring",
+		EnvVars: []string{"DPM_WARMUP_BUCKETS"},
+	}
+	warmupTimeoutFlag = &cli.DurationFlag{
+		Name:    "warmup-timeout",
+		Usage:   "How long the routing table is refreshed at most before measuring anyway",
+		EnvVars: []string{"DPM_WARMUP_TIMEOUT"},
+		Value:   time.Minute,
+	}
+	providerCPLFlag = &cli.IntFlag{
+		Name:        "provider-cpl",
+		Usage:       "Generate a provider identity whose peer ID shares this many leading bits with the content",
+		EnvVars:     []string{"DPM_PROVIDER_CPL"},
+		DefaultText: "random identity",
+	}
+	requesterCPLFlag = &cli.IntFlag{
+		Name:        "requester-cpl",
+		Usage:       "Generate requester identities whose peer IDs share this many leading bits with the content",
+		EnvVars:     []string{"DPM_REQUESTER_CPL"},
+		DefaultText: "random identity",
+	}
+	contentPathFlag = &cli.StringFlag{
+		Name:    "content-path",
+		Usage:   "Provide the data of this file or of every file in this directory instead of random content",
+		EnvVars: []string{"DPM_CONTENT_PATH"},
+	}
+	cidListFlag = &cli.StringFlag{
+		Name:    "cid-list",
+		Usage:   "Provide the CIDs in this file (one per line) instead of random content",
+		EnvVars: []string{"DPM_CID_LIST"},
+	}
+	contentSeedFlag = &cli.Int64Flag{
+		Name:    "content-seed",
+		Usage:   "Derive the random content from this seed to reproduce the CIDs of previous runs",
+		EnvVars: []string{"DPM_CONTENT_SEED"},
+	}
+	cidVersionFlag = &cli.Uint64Flag{
+		Name:    "cid-version",
+		Usage:   "The version of the CIDs of generated content (0 or 1)",
+		EnvVars: []string{"DPM_CID_VERSION"},
+	}
+	codecFlag = &cli.StringFlag{
+		Name:    "codec",
+		Usage:   "The multicodec of CIDv1s of generated content (e.g. raw, dag-pb, dag-cbor)",
+		EnvVars: []string{"DPM_CODEC"},
+		Value:   "dag-pb",
+	}
+	hashFlag = &cli.StringFlag{
+		Name:    "hash",
+		Usage:   "The hash function of generated content (e.g. sha2-256, blake2b-256, sha3-256)",
+		EnvVars: []string{"DPM_HASH"},
+		Value:   "sha2-256",
+	}
+)
 
-	sigs := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
-
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		sig := <-sigs
-		log.Infoln(sig)
-		done <- true
-	}()
-
-	log.Infoln("Awaiting user signal")
-	<-done
-	log.Infoln("Exiting")
+// sleepCtx blocks for the given duration or until the context is cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
 }
 
 func initZipkin() {
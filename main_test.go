@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeasureSimulated(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping simulated measurement in short mode")
+	}
+
+	out := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	args := []string{"dht-provide-measurement", "--out", out, "measure", "--simulate", "--sim-nodes", "30", "--grace-period", "1s"}
+	if err := newApp().RunContext(ctx, args); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := filepath.Glob(filepath.Join(out, "*_run-001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("got %d run directories, want 1", len(runs))
+	}
+
+	for _, name := range []string{"events.csv", "manifest.json", "provide_summary.json", "peers.csv"} {
+		if _, err := os.Stat(filepath.Join(runs[0], name)); err != nil {
+			t.Errorf("missing %s: %v", name, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(out, "summary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	as := &AggregateSummary{}
+	if err = json.Unmarshal(data, as); err != nil {
+		t.Fatal(err)
+	}
+	if as.Runs != 1 || as.FailedRuns != 0 {
+		t.Errorf("got %d runs with %d failed, want 1 successful run", as.Runs, as.FailedRuns)
+	}
+}
+
+func TestConfigCPLWithStateDir(t *testing.T) {
+	args := []string{"dht-provide-measurement", "--state-dir", t.TempDir(), "measure", "--simulate", "--provider-cpl", "2"}
+	if err := newApp().Run(args); err == nil || !strings.Contains(err.Error(), "--state-dir") {
+		t.Errorf("expected error for common prefix length with state directory, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/pkg/errors"
+)
+
+// NetworkProfile describes the network conditions of a simulated DHT network.
+// Every simulated peer is assigned to one of the regions based on their shares.
+// An example profile looks like this:
+//
+//	{
+//	  "self": "eu",
+//	  "regions": [
+//	    { "name": "eu", "share": 0.6, "latency": { "mean": "20ms", "stddev": "5ms" }, "bandwidth": 1250000 },
+//	    { "name": "as", "share": 0.4, "latency": { "mean": "120ms", "stddev": "30ms" }, "loss": 0.05 }
+//	  ]
+//	}
+type NetworkProfile struct {
+	// The name of the region the provider and requester are located in.
+	// If empty, the measurement hosts don't add any latency themselves.
+	Self    string          `json:"self"`
+	Regions []RegionProfile `json:"regions"`
+}
+
+// RegionProfile describes the network conditions of all peers in a region.
+type RegionProfile struct {
+	Name string `json:"name"`
+
+	// The relative number of peers that are located in this region.
+	Share float64 `json:"share"`
+
+	// The one-way latency between a peer of this region and the backbone.
+	// The latency of a link between two peers is the sum of both latencies.
+	Latency LatencyDistribution `json:"latency"`
+
+	// The probability that a peer of this region drops a response.
+	Loss float64 `json:"loss"`
+
+	// The bandwidth of a peer in bytes per second. Zero means unlimited.
+	Bandwidth float64 `json:"bandwidth"`
+}
+
+// LatencyDistribution is a normal distribution of latencies.
+type LatencyDistribution struct {
+	Mean   Duration `json:"mean"`
+	StdDev Duration `json:"stddev"`
+}
+
+// Sample draws a latency from the distribution. Negative latencies are clamped to zero.
+func (ld LatencyDistribution) Sample() time.Duration {
+	latency := time.Duration(rand.NormFloat64()*float64(ld.StdDev) + float64(ld.Mean))
+	if latency < 0 {
+		return 0
+	}
+	return latency
+}
+
+// Duration is a time.Duration that is represented as a string like "150ms" in JSON.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	dur, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(dur)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadNetworkProfile reads and validates the network profile from the given JSON file.
+func LoadNetworkProfile(filename string) (*NetworkProfile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "read network profile")
+	}
+
+	np := &NetworkProfile{}
+	if err = json.Unmarshal(data, np); err != nil {
+		return nil, errors.Wrap(err, "unmarshal network profile")
+	}
+
+	if len(np.Regions) == 0 {
+		return nil, fmt.Errorf("network profile has no regions")
+	}
+
+	for _, region := range np.Regions {
+		if region.Share < 0 {
+			return nil, fmt.Errorf("region %s has a negative share", region.Name)
+		}
+		if region.Loss < 0 || region.Loss > 1 {
+			return nil, fmt.Errorf("region %s has a loss probability outside of [0, 1]", region.Name)
+		}
+	}
+
+	if np.Self != "" && np.Region(np.Self) == nil {
+		return nil, fmt.Errorf("unknown self region %s", np.Self)
+	}
+
+	return np, nil
+}
+
+// Region returns the region with the given name or nil if it doesn't exist.
+func (np *NetworkProfile) Region(name string) *RegionProfile {
+	for i, region := range np.Regions {
+		if region.Name == name {
+			return &np.Regions[i]
+		}
+	}
+	return nil
+}
+
+// SampleConditions assigns a random region to a peer based on the
+// region shares and samples the network conditions of that peer.
+func (np *NetworkProfile) SampleConditions() PeerConditions {
+	total := 0.0
+	for _, region := range np.Regions {
+		total += region.Share
+	}
+
+	r := rand.Float64() * total
+	for _, region := range np.Regions {
+		if r < region.Share {
+			return region.SampleConditions()
+		}
+		r -= region.Share
+	}
+
+	return np.Regions[len(np.Regions)-1].SampleConditions()
+}
+
+// SampleConditions samples the network conditions of a peer in this region.
+func (rp *RegionProfile) SampleConditions() PeerConditions {
+	return PeerConditions{
+		Region:    rp.Name,
+		Latency:   rp.Latency.Sample(),
+		Loss:      rp.Loss,
+		Bandwidth: rp.Bandwidth,
+	}
+}
+
+// PeerConditions are the network conditions of a single simulated peer.
+type PeerConditions struct {
+	Region    string
+	Latency   time.Duration
+	Loss      float64
+	Bandwidth float64
+}
+
+// lossyHost wraps a host and drops responses of all stream handlers
+// with the given probability to simulate packet loss.
+type lossyHost struct {
+	host.Host
+	loss float64
+}
+
+func (h *lossyHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.Host.SetStreamHandler(pid, func(s network.Stream) {
+		handler(&lossyStream{Stream: s, loss: h.loss})
+	})
+}
+
+// lossyStream silently discards writes with the given probability.
+type lossyStream struct {
+	network.Stream
+	loss float64
+}
+
+func (s *lossyStream) Write(p []byte) (int, error) {
+	if rand.Float64() < s.loss {
+		return len(p), nil
+	}
+	return s.Stream.Write(p)
+}
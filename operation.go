@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/atomic"
+)
+
+// operationCounter makes operation IDs unique within a process.
+var operationCounter = atomic.NewUint64(0)
+
+type operationKey struct{}
+
+// WithOperation returns a context that tags all events that are dispatched
+// on its behalf with a new operation ID of the given kind, e.g. "provide-3".
+// This allows telling apart the events of concurrent operations like
+// providing and monitoring the same content.
+func WithOperation(ctx context.Context, kind string) context.Context {
+	id := fmt.Sprintf("%s-%d", kind, operationCounter.Inc())
+	return context.WithValue(ctx, operationKey{}, id)
+}
+
+// OperationIDFromContext returns the ID of the operation that the given
+// context was tagged with or an empty string if it wasn't tagged.
+func OperationIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(operationKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
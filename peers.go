@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+// PeerMetadata holds what the identify protocol and the
+// peerstore of the measuring hosts know about a peer.
+type PeerMetadata struct {
+	PeerID          string
+	AgentVersion    string
+	ProtocolVersion string
+	Protocols       []string
+	ListenAddrs     []string
+
+	// Latency is the moving average of the round trip time in
+	// seconds. It is 0 if the latency is unknown.
+	Latency float64
+
+	// DialedAddr is the address of the last successful dial of the
+	// peer. It is empty if the peer was never dialed by us.
+	DialedAddr string
+
+	// The location and autonomous system of the dialed address or, if the
+	// peer wasn't dialed, of the first public listen address. They are only
+	// set if the respective GeoIP databases are configured.
+	IP      string
+	Country string
+	City    string
+	ASN     uint
+	ASOrg   string
+}
+
+// NewPeerMetadata looks up the metadata of the given peer in the peerstores
+// of the given hosts. Fields that are unknown to a host are taken from the
+// next one.
+func NewPeerMetadata(peerID peer.ID, hosts ...host.Host) *PeerMetadata {
+	pm := &PeerMetadata{PeerID: peerID.Pretty()}
+
+	for _, h := range hosts {
+		ps := h.Peerstore()
+
+		if pm.AgentVersion == "" {
+			if av, err := ps.Get(peerID, "AgentVersion"); err == nil {
+				pm.AgentVersion, _ = av.(string)
+			}
+		}
+
+		if pm.ProtocolVersion == "" {
+			if pv, err := ps.Get(peerID, "ProtocolVersion"); err == nil {
+				pm.ProtocolVersion, _ = pv.(string)
+			}
+		}
+
+		if len(pm.Protocols) == 0 {
+			if protocols, err := ps.GetProtocols(peerID); err == nil {
+				sort.Strings(protocols)
+				pm.Protocols = protocols
+			}
+		}
+
+		if len(pm.ListenAddrs) == 0 {
+			for _, maddr := range ps.Addrs(peerID) {
+				pm.ListenAddrs = append(pm.ListenAddrs, maddr.String())
+			}
+		}
+
+		if pm.Latency == 0 {
+			pm.Latency = ps.LatencyEWMA(peerID).Seconds()
+		}
+	}
+
+	return pm
+}
+
+// Locate sets the location and autonomous system of the peer from the given
+// GeoIP databases. The dialed address is preferred over the listen addresses.
+func (pm *PeerMetadata) Locate(geo *GeoIP, dialed ma.Multiaddr) {
+	var maddrs []ma.Multiaddr
+	if dialed != nil {
+		pm.DialedAddr = dialed.String()
+		maddrs = append(maddrs, dialed)
+	}
+
+	for _, addr := range pm.ListenAddrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err == nil {
+			maddrs = append(maddrs, maddr)
+		}
+	}
+
+	gi := geo.Lookup(maddrs...)
+	if gi == nil {
+		return
+	}
+
+	pm.IP = gi.IP
+	pm.Country = gi.Country
+	pm.City = gi.City
+	pm.ASN = gi.ASN
+	pm.ASOrg = gi.ASOrg
+}
+
+// PeersFilename returns the path of the peers table in the given directory.
+func PeersFilename(dir string) string {
+	return filepath.Join(dir, "peers.csv")
+}
+
+// peersHeader is the header of the peers table. Lists are joined with commas.
+var peersHeader = []string{
+	"peer_id",
+	"agent_version",
+	"protocol_version",
+	"protocols",
+	"listen_addrs",
+	"latency_s",
+	"dialed_addr",
+	"ip",
+	"country",
+	"city",
+	"asn",
+	"as_org",
+}
+
+// SavePeers writes the metadata of all relevant peers as found in the
+// peerstores of the given hosts to the given CSV file. The peers are located
+// with the given GeoIP databases, which may be nil. It must be called before
+// the hosts are closed.
+func (eh *EventHub) SavePeers(filename string, geo *GeoIP, hosts ...host.Host) error {
+	ss, err := eh.statsSink()
+	if err != nil {
+		return err
+	}
+	dialed := ss.DialedAddrs()
+
+	var peers []peer.ID
+	eh.relevant.Range(func(key, value interface{}) bool {
+		peers = append(peers, key.(peer.ID))
+		return true
+	})
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i] < peers[j]
+	})
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrap(err, "create peers file")
+	}
+
+	if err = writePeers(f, peers, dialed, geo, hosts...); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return errors.Wrap(f.Close(), "close peers file")
+}
+
+// writePeers writes the header and the metadata of the given peers as CSV to out.
+func writePeers(out io.Writer, peers []peer.ID, dialed map[peer.ID]ma.Multiaddr, geo *GeoIP, hosts ...host.Host) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(peersHeader); err != nil {
+		return errors.Wrap(err, "write header")
+	}
+
+	for _, peerID := range peers {
+		pm := NewPeerMetadata(peerID, hosts...)
+		pm.Locate(geo, dialed[peerID])
+
+		asn := ""
+		if pm.ASN != 0 {
+			asn = strconv.FormatUint(uint64(pm.ASN), 10)
+		}
+
+		err := w.Write([]string{
+			pm.PeerID,
+			pm.AgentVersion,
+			pm.ProtocolVersion,
+			strings.Join(pm.Protocols, ","),
+			strings.Join(pm.ListenAddrs, ","),
+			fmt.Sprintf("%.6f", pm.Latency),
+			pm.DialedAddr,
+			pm.IP,
+			pm.Country,
+			pm.City,
+			asn,
+			pm.ASOrg,
+		})
+		if err != nil {
+			return errors.Wrap(err, "write peer")
+		}
+	}
+
+	w.Flush()
+	return errors.Wrap(w.Error(), "flush peers file")
+}
+
+// savePeers writes the peers table to the given directory and locates the
+// peers with the GeoIP databases that are configured by the user.
+func savePeers(conf *Config, eh *EventHub, dir string, hosts ...host.Host) error {
+	geo, err := NewGeoIPFromConfig(conf)
+	if err != nil {
+		return err
+	}
+	defer geo.Close()
+
+	return eh.SavePeers(PeersFilename(dir), geo, hosts...)
+}
+
+// LoadPeers reads the peers table that was written by SavePeers
+// and returns the metadata by peer ID.
+func LoadPeers(filename string) (map[string]*PeerMetadata, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "open peers file")
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	if _, err = cr.Read(); err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+
+	peers := map[string]*PeerMetadata{}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return peers, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "read peer")
+		}
+
+		pm := &PeerMetadata{
+			PeerID:          row[0],
+			AgentVersion:    row[1],
+			ProtocolVersion: row[2],
+		}
+		if row[3] != "" {
+			pm.Protocols = strings.Split(row[3], ",")
+		}
+		if row[4] != "" {
+			pm.ListenAddrs = strings.Split(row[4], ",")
+		}
+		fmt.Sscanf(row[5], "%f", &pm.Latency)
+
+		// Tables of older versions end with the latency.
+		if len(row) > 6 {
+			pm.DialedAddr = row[6]
+			pm.IP = row[7]
+			pm.Country = row[8]
+			pm.City = row[9]
+			fmt.Sscanf(row[10], "%d", &pm.ASN)
+			pm.ASOrg = row[11]
+		}
+
+		peers[pm.PeerID] = pm
+	}
+}
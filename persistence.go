@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+// PersistenceMonitor keeps track of the closest peers to some content
+// over a long period of time and periodically asks all of them for the
+// provider record. It records when peers join or leave the set of closest
+// peers due to churn and when they store or drop the record.
+type PersistenceMonitor struct {
+	r       *Requester
+	content *Content
+
+	// The time that all relative times are based on.
+	start time.Time
+
+	mutex sync.Mutex
+	peers map[peer.ID]*PeerPersistence
+	wg    sync.WaitGroup
+}
+
+// PeerPersistence describes the provider record persistence at a single peer.
+// All times are in seconds relative to the start of the monitoring. They are
+// null if the respective event didn't happen.
+type PeerPersistence struct {
+	PeerID string `json:"peer_id"`
+
+	// Initial is true if the peer was among the closest peers from the start.
+	Initial bool `json:"initial"`
+
+	JoinedAt      float64  `json:"joined_at_s"`
+	LeftAt        *float64 `json:"left_at_s"`
+	FirstRecordAt *float64 `json:"first_record_at_s"`
+	DroppedAt     *float64 `json:"dropped_at_s"`
+	Polls         int      `json:"polls"`
+	FailedPolls   int      `json:"failed_polls"`
+
+	closest   bool
+	hasRecord bool
+}
+
+// NewPersistenceMonitor initializes a monitor for the provider
+// records of the given content. Relative times are based on start.
+func NewPersistenceMonitor(r *Requester, content *Content, start time.Time) *PersistenceMonitor {
+	return &PersistenceMonitor{
+		r:       r,
+		content: content,
+		start:   start,
+		peers:   map[peer.ID]*PeerPersistence{},
+	}
+}
+
+// Run looks up the closest peers every closestInterval and asks every
+// peer that was ever among them for the provider record every interval.
+// It blocks until the context is cancelled and all polls have finished.
+func (pm *PersistenceMonitor) Run(ctx context.Context, interval time.Duration, closestInterval time.Duration) {
+	ticker := time.NewTicker(closestInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := pm.updateClosestPeers(ctx, interval); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warnln("Could not update closest peers")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			pm.wg.Wait()
+			return
+		}
+	}
+}
+
+// updateClosestPeers looks up the current closest peers to the content,
+// dispatches events for peers that joined or left and starts polling
+// newly discovered peers.
+func (pm *PersistenceMonitor) updateClosestPeers(ctx context.Context, interval time.Duration) error {
+	ctx = WithOperation(WithContent(ctx, pm.content), "lookup")
+
+	closest, err := pm.r.dht.GetClosestPeers(ctx, string(pm.content.mhash))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	current := map[peer.ID]struct{}{}
+	for _, peerID := range closest {
+		current[peerID] = struct{}{}
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	initial := len(pm.peers) == 0
+	for peerID := range current {
+		pp, found := pm.peers[peerID]
+		if found && pp.closest {
+			continue
+		}
+
+		if !found {
+			pp = &PeerPersistence{
+				PeerID:   peerID.Pretty(),
+				Initial:  initial,
+				JoinedAt: now.Sub(pm.start).Seconds(),
+			}
+			pm.peers[peerID] = pp
+
+			pm.r.eh.MarkAsRelevant(peerID)
+			pm.wg.Add(1)
+			go pm.poll(ctx, peerID, interval)
+		}
+		pp.closest = true
+		pp.LeftAt = nil
+
+		if !initial {
+			log.WithField("targetID", shortPeerID(peerID)).Infoln("New closest peer")
+			pm.r.eh.PushEvent(&ClosestPeerJoined{
+				BaseEvent: BaseEvent{ID: peerID, Time: now, CID: pm.content.cid, Operation: OperationIDFromContext(ctx)},
+			})
+		}
+	}
+
+	for peerID, pp := range pm.peers {
+		if _, found := current[peerID]; found || !pp.closest {
+			continue
+		}
+
+		log.WithField("targetID", shortPeerID(peerID)).Infoln("Peer left the closest peers")
+		pp.closest = false
+		leftAt := now.Sub(pm.start).Seconds()
+		pp.LeftAt = &leftAt
+		pm.r.eh.PushEvent(&ClosestPeerLeft{
+			BaseEvent: BaseEvent{ID: peerID, Time: now, CID: pm.content.cid, Operation: OperationIDFromContext(ctx)},
+		})
+	}
+
+	return nil
+}
+
+// poll periodically asks the given peer for the provider record until the
+// context is cancelled. In contrast to MonitorProviders polling continues
+// after the peer has returned the record or failed to respond.
+func (pm *PersistenceMonitor) poll(ctx context.Context, peerID peer.ID, interval time.Duration) {
+	defer pm.wg.Done()
+
+	ctx = WithOperation(ctx, "persistence")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		pm.r.eh.PushEvent(&MonitorProviderStart{
+			BaseEvent: BaseEvent{ID: peerID, Time: time.Now(), CID: pm.content.cid, Operation: OperationIDFromContext(ctx)},
+		})
+
+		provs, _, err := pm.r.pm.GetProviders(ctx, peerID, pm.content.mhash)
+		if ctx.Err() != nil {
+			return
+		}
+
+		now := time.Now()
+		if err == nil && len(provs) == 0 {
+			err = ErrNoProviderRecord
+		}
+		pm.r.eh.PushEvent(&MonitorProviderEnd{
+			BaseEvent: BaseEvent{ID: peerID, Time: now, CID: pm.content.cid, Operation: OperationIDFromContext(ctx)},
+			Err:       err,
+		})
+
+		pm.mutex.Lock()
+		pp := pm.peers[peerID]
+		pp.Polls += 1
+		switch err {
+		case nil:
+			if !pp.hasRecord && pp.FirstRecordAt == nil {
+				firstRecordAt := now.Sub(pm.start).Seconds()
+				pp.FirstRecordAt = &firstRecordAt
+			}
+			pp.hasRecord = true
+		case ErrNoProviderRecord:
+			// Only an explicit empty response counts as dropping the
+			// record. The peer may just be unreachable temporarily.
+			if pp.hasRecord {
+				pp.hasRecord = false
+				droppedAt := now.Sub(pm.start).Seconds()
+				pp.DroppedAt = &droppedAt
+				log.WithField("targetID", shortPeerID(peerID)).Infoln("Peer dropped the provider record")
+				pm.r.eh.PushEvent(&RecordDropped{
+					BaseEvent: BaseEvent{ID: peerID, Time: now, CID: pm.content.cid, Operation: OperationIDFromContext(ctx)},
+				})
+			}
+		default:
+			pp.FailedPolls += 1
+		}
+		pm.mutex.Unlock()
+	}
+}
+
+// Save writes the persistence of the provider record at every peer that
+// was ever among the closest peers as JSON to the given file.
+func (pm *PersistenceMonitor) Save(filename string) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	peers := make([]*PeerPersistence, 0, len(pm.peers))
+	for _, pp := range pm.peers {
+		peers = append(peers, pp)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].JoinedAt < peers[j].JoinedAt
+	})
+
+	return writeJSON(filename, peers)
+}
@@ -2,78 +2,80 @@ package main
 
 import (
 	"context"
+	"sync"
 	"time"
 
-	"bou.ke/monkey"
-
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
-	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/libp2p/go-libp2p-core/routing"
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
 )
 
 type Provider struct {
 	h   host.Host
 	dht *kaddht.IpfsDHT
+	pm  *pb.ProtocolMessenger
 	eh  *EventHub
 }
 
-func NewProvider(ctx context.Context, eh *EventHub) (*Provider, error) {
-	key, _, err := crypto.GenerateKeyPair(crypto.Secp256k1, 256)
+// NewProvider constructs a provider whose host has the given identity
+// and only uses the given instrumented transports.
+func NewProvider(ctx context.Context, key crypto.PrivKey, transports []string, eh *EventHub) (*Provider, error) {
+	var (
+		dht *kaddht.IpfsDHT
+		pm  *pb.ProtocolMessenger
+		err error
+	)
+	h, err := libp2p.New(ctx,
+		libp2p.Identity(key),
+		transportOptions(eh, transports),
+		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+			dht, pm, err = newDHT(ctx, h, eh)
+			return dht, err
+		}))
 	if err != nil {
-		return nil, errors.Wrap(err, "generate key pair")
+		return nil, errors.Wrap(err, "new libp2p host")
 	}
 
-	ms := &messageSenderImpl{
-		protocols: protocol.ConvertFromStrings([]string{"/ipfs/kad/1.0.0"}),
-		strmap:    make(map[peer.ID]*peerMessageSender),
-		eventHub:  eh,
-	}
+	return newProvider(h, dht, pm, eh), nil
+}
 
-	pm, err := pb.NewProtocolMessenger(ms)
+// NewSimulatedProvider constructs a provider whose host has the
+// given identity and is part of the given simulated DHT network.
+func NewSimulatedProvider(ctx context.Context, sim *Simulation, key crypto.PrivKey, eh *EventHub) (*Provider, error) {
+	h, err := sim.NewHost(key, eh)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "new simulated host")
 	}
 
-	monkey.Patch(pb.NewProtocolMessenger, func(msgSender pb.MessageSender, opts ...pb.ProtocolMessengerOption) (*pb.ProtocolMessenger, error) {
-		for _, o := range opts {
-			if err := o(pm); err != nil {
-				return nil, err
-			}
-		}
-		return pm, nil
-	})
-
-	var dht *kaddht.IpfsDHT
-	h, err := libp2p.New(ctx,
-		libp2p.Identity(key),
-		libp2p.DefaultListenAddrs,
-		libp2p.Transport(NewTCPTransport(eh)),
-		libp2p.Transport(NewWSTransport(eh)),
-		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			dht, err = kaddht.New(ctx, h)
-			return dht, err
-		}))
+	dht, pm, err := newDHT(ctx, h, eh, kaddht.BootstrapPeers())
 	if err != nil {
-		return nil, errors.Wrap(err, "new libp2p host")
+		return nil, err
 	}
-	ms.host = h
 
+	return newProvider(h, dht, pm, eh), nil
+}
+
+// newProvider assembles a provider. All requests and messages of its DHT,
+// including the routing table maintenance, are recorded. The given protocol
+// messenger shares the message sender of the DHT.
+func newProvider(h host.Host, dht *kaddht.IpfsDHT, pm *pb.ProtocolMessenger, eh *EventHub) *Provider {
 	return &Provider{
 		h:   h,
 		dht: dht,
+		pm:  pm,
 		eh:  eh,
-	}, nil
+	}
 }
 
-func (p *Provider) Bootstrap(ctx context.Context) error {
-	for _, bp := range kaddht.GetDefaultBootstrapPeerAddrInfos() {
+func (p *Provider) Bootstrap(ctx context.Context, peers []peer.AddrInfo) error {
+	for _, bp := range peers {
 		log.WithField("type", "provider").Infoln("Connecting to bootstrap peer")
 		if err := p.h.Connect(ctx, bp); err != nil {
 			return errors.Wrap(err, "connecting to bootstrap peer")
@@ -82,18 +84,225 @@ func (p *Provider) Bootstrap(ctx context.Context) error {
 	return nil
 }
 
-func (p *Provider) InitRoutingTable() {
-	<-p.dht.RefreshRoutingTable()
+// WarmUp refreshes the routing table until it meets the configured
+// warm-up criteria. It returns nil if no warm-up is configured.
+func (p *Provider) WarmUp(ctx context.Context, conf *Config) *WarmupResult {
+	if conf.WarmupPeers <= 0 && conf.WarmupBuckets <= 0 {
+		return nil
+	}
+	return WarmUp(ctx, p.dht, conf.WarmupPeers, conf.WarmupBuckets, conf.WarmupTimeout)
+}
+
+// RoutingTableSnapshot captures the current routing table with the
+// distances of the peers relative to the given content.
+func (p *Provider) RoutingTableSnapshot(phase string, content *Content) *RoutingTableSnapshot {
+	return NewRoutingTableSnapshot("provider", phase, p.h, p.dht, content)
 }
 
+// RoutingTableStats returns the current size and bucket occupancy of the routing table.
+func (p *Provider) RoutingTableStats() *RoutingTableStats {
+	return NewRoutingTableStats(p.h.ID(), p.dht.RoutingTable().ListPeers())
+}
+
+// Provide starts the event hub and announces the given content in the DHT.
+// The caller is responsible for stopping the event hub afterwards.
+//
+// This mirrors kaddht.IpfsDHT.Provide but dispatches events between the
+// lookup of the closest peers and the ADD_PROVIDER phase, so the latency
+// of each phase can be attributed precisely.
 func (p *Provider) Provide(ctx context.Context, content *Content) error {
 	ctx = p.eh.Start(ctx, p.h)
-	err := p.dht.Provide(ctx, content.cid, true)
-	go func() {
-		<-time.After(5 * time.Second)
-		log.Infoln("Serializing events")
-		p.eh.Stop(p.h)
-		p.eh.Serialize(content)
-	}()
+
+	// The phase events carry our own peer ID.
+	p.eh.MarkAsRelevant(p.h.ID())
+
+	return p.provideRound(ctx, content, 0)
+}
+
+// ProvideBatch starts the event hub and announces all given contents in the
+// DHT. Up to concurrency contents are provided at the same time. All events
+// are tagged with the CID of the content they belong to. Failed provides are
+// only logged as their errors are recorded in the ProvideEnd events. The
+// caller is responsible for stopping the event hub afterwards.
+func (p *Provider) ProvideBatch(ctx context.Context, contents []*Content, concurrency int) error {
+	ctx = p.eh.Start(ctx, p.h)
+	p.eh.MarkAsRelevant(p.h.ID())
+
+	queue := make(chan *Content)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for content := range queue {
+				if err := p.provideRound(ctx, content, 0); err != nil {
+					log.WithError(err).WithField("cid", content.cid.String()).Warnln("Could not provide content")
+				}
+			}
+		}()
+	}
+
+	for i, content := range contents {
+		select {
+		case queue <- content:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if (i+1)%100 == 0 {
+			log.WithField("provides", i+1).WithField("total", len(contents)).Infoln("Batch progress")
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// Reprovide announces the given content again. The phase events of the
+// reprovide are labeled with the given round. The event hub must have
+// been started by a previous call to Provide.
+func (p *Provider) Reprovide(ctx context.Context, content *Content, round int) error {
+	ctx = p.eh.TrackQueries(ctx)
+	return p.provideRound(ctx, content, round)
+}
+
+// ReprovideRounds reprovides the given content the given number of times.
+// The first reprovide starts after the given interval has passed since
+// this method was called and every following one an interval after the
+// previous has finished.
+func (p *Provider) ReprovideRounds(ctx context.Context, content *Content, rounds int, interval time.Duration) error {
+	for round := 1; round <= rounds; round++ {
+		log.WithField("round", round).WithField("interval", interval).Infoln("Waiting for next reprovide")
+		sleepCtx(ctx, interval)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := p.Reprovide(ctx, content, round); err != nil {
+			return errors.Wrapf(err, "reprovide round %d", round)
+		}
+	}
+	return nil
+}
+
+// provideRound announces the content while dispatching the phase events
+// that are labeled with the given round.
+func (p *Provider) provideRound(ctx context.Context, content *Content, round int) error {
+	ctx = WithOperation(WithContent(ctx, content), "provide")
+
+	p.eh.PushEvent(&ProvideStart{
+		BaseEvent: BaseEvent{ID: p.h.ID(), Time: time.Now(), CID: content.cid, Operation: OperationIDFromContext(ctx)},
+		Round:     round,
+	})
+
+	sent, err := p.provide(ctx, content, round)
+
+	p.eh.PushEvent(&ProvideEnd{
+		BaseEvent: BaseEvent{ID: p.h.ID(), Time: time.Now(), CID: content.cid, Operation: OperationIDFromContext(ctx)},
+		Round:     round,
+		PeersSent: sent,
+		Err:       err,
+	})
+
 	return err
 }
+
+// provide looks up the closest peers and sends them ADD_PROVIDER messages.
+// It returns the number of peers the message was successfully sent to.
+// ADD_PROVIDER messages aren't acknowledged, so this doesn't tell whether
+// the peers actually stored the record.
+//
+// This is kaddht.IpfsDHT.Provide with broadcasting enabled, split into its
+// lookup and ADD_PROVIDER phases. Both phases go through the instrumented
+// message sender of the DHT. The DHT of the provider is always constructed
+// with providers enabled, so that isn't checked, and the returned errors
+// are wrapped.
+func (p *Provider) provide(ctx context.Context, content *Content, round int) (int, error) {
+	if !content.cid.Defined() {
+		return 0, errors.New("invalid cid: undefined")
+	}
+
+	p.dht.ProviderManager.AddProvider(ctx, content.mhash, p.h.ID())
+
+	closerCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout < 0 {
+			return 0, context.DeadlineExceeded
+		} else if timeout < 10*time.Second {
+			// Reserve 10% for the ADD_PROVIDER messages.
+			deadline = deadline.Add(-timeout / 10)
+		} else {
+			// Otherwise, reserve a second as we'll
+			// already be connected to the peers.
+			deadline = deadline.Add(-time.Second)
+		}
+
+		var cancel context.CancelFunc
+		closerCtx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	peers, err := p.dht.GetClosestPeers(closerCtx, string(content.mhash))
+	p.eh.PushEvent(&LookupDone{
+		BaseEvent:    BaseEvent{ID: p.h.ID(), Time: time.Now(), CID: content.cid, Operation: OperationIDFromContext(ctx)},
+		Round:        round,
+		ClosestPeers: peers,
+		Err:          err,
+	})
+
+	// If only the inner deadline was exceeded, the record is sent
+	// to the closest peers that were found so far.
+	exceededDeadline := false
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		exceededDeadline = true
+	} else if err != nil {
+		return 0, errors.Wrap(err, "get closest peers")
+	}
+
+	p.eh.PushEvent(&AddProviderStart{
+		BaseEvent: BaseEvent{ID: p.h.ID(), Time: time.Now(), CID: content.cid, Operation: OperationIDFromContext(ctx)},
+		Round:     round,
+	})
+
+	sent := atomic.NewInt32(0)
+	var wg sync.WaitGroup
+	for _, peerID := range peers {
+		wg.Add(1)
+		go func(peerID peer.ID) {
+			defer wg.Done()
+			if err := p.pm.PutProvider(ctx, peerID, content.mhash, p.h); err != nil {
+				log.WithError(err).WithField("peerID", shortPeerID(peerID)).Debugln("Could not put provider record")
+				return
+			}
+			sent.Inc()
+		}(peerID)
+	}
+	wg.Wait()
+
+	if exceededDeadline {
+		return int(sent.Load()), context.DeadlineExceeded
+	}
+	return int(sent.Load()), ctx.Err()
+}
+
+// RestoreState restores the routing table and peerstore of a previous run
+// from the state directory. It returns the number of restored peers.
+func (p *Provider) RestoreState(conf *Config) (int, error) {
+	return restoreState(conf, "provider", p.h, p.dht)
+}
+
+// SaveState saves the routing table and peerstore to the state directory.
+func (p *Provider) SaveState(conf *Config) error {
+	return saveState(conf, "provider", p.h, p.dht)
+}
+
+// Close shuts down the DHT and the libp2p host of the provider.
+func (p *Provider) Close() error {
+	if err := p.dht.Close(); err != nil {
+		return errors.Wrap(err, "close dht")
+	}
+	return p.h.Close()
+}
@@ -0,0 +1,727 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	u "github.com/ipfs/go-ipfs-util"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// The supported serialization formats of events.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// EventRecord is the serializable representation of an event. Fields
+// that don't apply to the type of the event are left empty.
+type EventRecord struct {
+	PeerID   string `json:"peer_id"`
+	Distance string `json:"distance"`
+
+	// CID is the content the event belongs to. It is empty for events
+	// that weren't dispatched on behalf of a specific content.
+	CID string `json:"cid,omitempty"`
+
+	// Operation is the ID of the operation the event belongs to, e.g.
+	// a provide round or the monitoring of a single peer.
+	Operation string `json:"operation,omitempty"`
+
+	// Time is the number of seconds relative to the start of the provide
+	// operation. Streamed records only carry the absolute Timestamp.
+	Time      float64 `json:"time"`
+	Timestamp int64   `json:"timestamp_ns"`
+
+	Type     string `json:"type"`
+	HasError bool   `json:"has_error"`
+	Error    string `json:"error,omitempty"`
+
+	// ErrorClass is the stable category of the error, e.g. "timeout".
+	ErrorClass ErrorClass `json:"error_class,omitempty"`
+
+	Transport string          `json:"transport,omitempty"`
+	Maddr     string          `json:"maddr,omitempty"`
+	Protocols []string        `json:"protocols,omitempty"`
+	Message   *MessageSummary `json:"message,omitempty"`
+
+	Discovered         string `json:"discovered,omitempty"`
+	DiscoveredDistance string `json:"discovered_distance,omitempty"`
+
+	// Peers holds the closest peers that were found by the lookup.
+	Peers []string `json:"peers,omitempty"`
+
+	// PeersSent is the number of peers that the ADD_PROVIDER message was sent to.
+	PeersSent int `json:"peers_sent,omitempty"`
+
+	// The following fields are set for retrieval events. Target is
+	// the queried peer or the provider that was found. Round is
+	// also set for the phase events of reprovides.
+	Round        int     `json:"round,omitempty"`
+	Target       string  `json:"target,omitempty"`
+	Hops         int     `json:"hops,omitempty"`
+	PeersQueried int     `json:"peers_queried,omitempty"`
+	Duration     float64 `json:"duration_s,omitempty"`
+}
+
+// MessageSummary captures the relevant fields of a DHT protocol message.
+type MessageSummary struct {
+	Type          string        `json:"type"`
+	Key           string        `json:"key,omitempty"`
+	ClusterLevel  int32         `json:"cluster_level,omitempty"`
+	HasRecord     bool          `json:"has_record,omitempty"`
+	CloserPeers   []PeerSummary `json:"closer_peers,omitempty"`
+	ProviderPeers []PeerSummary `json:"provider_peers,omitempty"`
+}
+
+// PeerSummary is a peer that was sent as part of a DHT protocol message.
+type PeerSummary struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs,omitempty"`
+}
+
+// NewEventRecord converts the given event into a record. The distance fields
+// are computed relative to the content the event was tagged with or, if it
+// wasn't tagged, relative to the given content. The relative time is set if
+// start is not the zero time.
+func NewEventRecord(evt Event, content *Content, start time.Time) *EventRecord {
+	if evt.ContentID().Defined() {
+		content = NewContentFromCID(evt.ContentID())
+	}
+
+	r := &EventRecord{
+		PeerID:    evt.PeerID().Pretty(),
+		Distance:  distance(evt.PeerID(), content),
+		Timestamp: evt.TimeStamp().UnixNano(),
+		Type:      reflect.TypeOf(evt).Elem().Name(),
+		HasError:  evt.Error() != nil,
+	}
+
+	if evt.ContentID().Defined() {
+		r.CID = evt.ContentID().String()
+	}
+	r.Operation = evt.OperationID()
+
+	if !start.IsZero() {
+		r.Time = evt.TimeStamp().Sub(start).Seconds()
+	}
+
+	if evt.Error() != nil {
+		r.Error = strings.ReplaceAll(evt.Error().Error(), "\n", " ")
+		r.ErrorClass = ClassifyError(evt.Error())
+	}
+
+	switch event := evt.(type) {
+	case *DialStart:
+		r.Transport = event.Transport
+		r.Maddr = maddrString(event.Maddr)
+	case *DialEnd:
+		r.Transport = event.Transport
+		r.Maddr = maddrString(event.Maddr)
+	case *ConnectEnd:
+		r.Transport = event.Transport
+		r.Maddr = maddrString(event.Maddr)
+	case *SecurityStart:
+		r.Transport = event.Transport
+		r.Maddr = maddrString(event.Maddr)
+	case *SecurityEnd:
+		r.Transport = event.Transport
+		r.Maddr = maddrString(event.Maddr)
+		r.Protocols = []string{event.Protocol}
+	case *MuxerStart:
+		r.Transport = event.Transport
+		r.Maddr = maddrString(event.Maddr)
+	case *MuxerEnd:
+		r.Transport = event.Transport
+		r.Maddr = maddrString(event.Maddr)
+		r.Protocols = []string{event.Protocol}
+	case *OpenStreamStart:
+		r.Protocols = protocol.ConvertToStrings(event.Protocols)
+	case *OpenStreamEnd:
+		r.Protocols = protocol.ConvertToStrings(event.Protocols)
+	case *OpenedStream:
+		r.Protocols = protocol.ConvertToStrings([]protocol.ID{event.Protocol})
+	case *ClosedStream:
+		r.Protocols = protocol.ConvertToStrings([]protocol.ID{event.Protocol})
+	case *SendRequestStart:
+		r.Message = NewMessageSummary(event.Request)
+	case *SendRequestEnd:
+		r.Message = NewMessageSummary(event.Response)
+	case *SendMessageStart:
+		r.Message = NewMessageSummary(event.Message)
+	case *DiscoveredPeer:
+		r.Discovered = event.Discovered.Pretty()
+		r.DiscoveredDistance = distance(event.Discovered, content)
+	case *ProvideStart:
+		r.Round = event.Round
+	case *LookupDone:
+		r.Round = event.Round
+		for _, p := range event.ClosestPeers {
+			r.Peers = append(r.Peers, p.Pretty())
+		}
+	case *AddProviderStart:
+		r.Round = event.Round
+	case *ProvideEnd:
+		r.Round = event.Round
+		r.PeersSent = event.PeersSent
+	case *RetrievalStart:
+		r.Round = event.Round
+	case *RetrievalQuery:
+		r.Round = event.Round
+		r.Target = event.Target.Pretty()
+		r.Hops = event.Hop
+	case *ProviderFound:
+		r.Round = event.Round
+		r.Target = event.Provider.Pretty()
+		r.Hops = event.Hops
+		r.Duration = event.Duration.Seconds()
+	case *RetrievalEnd:
+		r.Round = event.Round
+		r.Hops = event.Hops
+		r.PeersQueried = event.PeersQueried
+		r.Duration = event.Duration.Seconds()
+	}
+
+	return r
+}
+
+// distance returns the hex encoded XOR distance between the given peer and
+// content in the DHT key space. It is empty if the content is unknown.
+func distance(peerID peer.ID, content *Content) string {
+	if content == nil {
+		return ""
+	}
+	return hex.EncodeToString(u.XOR(kbucket.ConvertPeerID(peerID), kbucket.ConvertKey(string(content.mhash))))
+}
+
+// maddrString returns the string representation of the given multi
+// address or an empty string if the address is unknown.
+func maddrString(maddr ma.Multiaddr) string {
+	if maddr == nil {
+		return ""
+	}
+	return maddr.String()
+}
+
+// NewMessageSummary summarizes the given message. It returns nil if msg is nil.
+func NewMessageSummary(msg *pb.Message) *MessageSummary {
+	if msg == nil {
+		return nil
+	}
+
+	return &MessageSummary{
+		Type:          msg.Type.String(),
+		Key:           hex.EncodeToString(msg.Key),
+		ClusterLevel:  int32(msg.GetClusterLevel()),
+		HasRecord:     msg.Record != nil,
+		CloserPeers:   newPeerSummaries(msg.CloserPeers),
+		ProviderPeers: newPeerSummaries(msg.ProviderPeers),
+	}
+}
+
+func newPeerSummaries(pbps []pb.Message_Peer) []PeerSummary {
+	var summaries []PeerSummary
+	for _, pi := range pb.PBPeersToPeerInfos(pbps) {
+		summary := PeerSummary{ID: pi.ID.Pretty()}
+		for _, addr := range pi.Addrs {
+			summary.Addrs = append(summary.Addrs, addr.String())
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// Extra returns the most important type specific information of the record
+// as a single string. This is the content of the extra column in CSV files.
+func (r *EventRecord) Extra() string {
+	switch r.Type {
+	case "DialStart", "DialEnd", "ConnectEnd", "SecurityStart", "MuxerStart":
+		return r.Maddr
+	case "SecurityEnd", "MuxerEnd":
+		return strings.Join(r.Protocols, ",")
+	case "OpenStreamStart", "OpenStreamEnd", "OpenedStream", "ClosedStream":
+		return strings.Join(r.Protocols, ",")
+	case "SendRequestStart", "SendRequestEnd", "SendMessageStart":
+		if r.Message != nil {
+			return r.Message.Type
+		}
+	case "DiscoveredPeer":
+		return r.DiscoveredDistance
+	case "LookupDone":
+		return strings.Join(r.Peers, ",")
+	case "RetrievalQuery", "ProviderFound":
+		return r.Target
+	}
+	return ""
+}
+
+// EventsFilename returns the path of the events file in the given format.
+func EventsFilename(dir string, format string) string {
+	return filepath.Join(dir, "events."+format)
+}
+
+// formatFromFilename derives the serialization format from the file extension.
+func formatFromFilename(filename string) (string, error) {
+	switch format := strings.TrimPrefix(filepath.Ext(filename), "."); format {
+	case FormatCSV, FormatJSONL, FormatParquet:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported events file format %q", format)
+	}
+}
+
+// EventRecordWriter serializes event records to a file.
+type EventRecordWriter interface {
+	Write(r *EventRecord) error
+	Close() error
+}
+
+// NewEventRecordWriter creates the given file and returns a writer
+// for the format that is indicated by the file extension.
+func NewEventRecordWriter(filename string) (EventRecordWriter, error) {
+	format, err := formatFromFilename(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatCSV:
+		return newCSVRecordWriter(filename)
+	case FormatJSONL:
+		return newJSONLRecordWriter(filename)
+	default:
+		return newParquetRecordWriter(filename)
+	}
+}
+
+// ForEachEventRecord reads all records of the given events file and calls
+// fn for each of them. The format is derived from the file extension.
+func ForEachEventRecord(filename string, fn func(r *EventRecord) error) error {
+	format, err := formatFromFilename(filename)
+	if err != nil {
+		return err
+	}
+
+	// Files written before errors were classified lack the error
+	// class, so it's derived from the error message instead.
+	classify := func(r *EventRecord) error {
+		if r.HasError && r.ErrorClass == ErrorClassNone {
+			r.ErrorClass = ClassifyErrorMessage(r.Error)
+		}
+		return fn(r)
+	}
+
+	switch format {
+	case FormatCSV:
+		return forEachCSVRecord(filename, classify)
+	case FormatJSONL:
+		return forEachJSONLRecord(filename, classify)
+	default:
+		return forEachParquetRecord(filename, classify)
+	}
+}
+
+// csvRecordWriter writes records in the original flat CSV format where all
+// type specific information is squashed into the extra column.
+type csvRecordWriter struct {
+	f *os.File
+	w *csv.Writer
+}
+
+// csvHeader is the header of event CSV files.
+var csvHeader = []string{
+	"peer_id",
+	"distance",
+	"time",
+	"type",
+	"has_error",
+	"error",
+	"extra",
+	"cid",
+	"operation",
+	"error_class",
+}
+
+func newCSVRecordWriter(filename string) (*csvRecordWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "create events file")
+	}
+
+	w := csv.NewWriter(f)
+	if err = w.Write(csvHeader); err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "write header")
+	}
+
+	return &csvRecordWriter{f: f, w: w}, nil
+}
+
+func (cw *csvRecordWriter) Write(r *EventRecord) error {
+	return cw.w.Write([]string{
+		r.PeerID,
+		r.Distance,
+		fmt.Sprintf("%.6f", r.Time),
+		r.Type,
+		strconv.FormatBool(r.HasError),
+		r.Error,
+		r.Extra(),
+		r.CID,
+		r.Operation,
+		string(r.ErrorClass),
+	})
+}
+
+func (cw *csvRecordWriter) Close() error {
+	cw.w.Flush()
+	if err := cw.w.Error(); err != nil {
+		_ = cw.f.Close()
+		return err
+	}
+	return cw.f.Close()
+}
+
+func forEachCSVRecord(filename string, fn func(r *EventRecord) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "open events file")
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	if _, err = cr.Read(); err != nil {
+		return errors.Wrap(err, "read header")
+	}
+
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "read record")
+		}
+
+		t, err := strconv.ParseFloat(row[2], 64)
+		if err != nil {
+			return errors.Wrap(err, "parse time")
+		}
+
+		r := &EventRecord{
+			PeerID:   row[0],
+			Distance: row[1],
+			Time:     t,
+			// Older files contain the Go type names, e.g. *main.DialStart.
+			Type:     strings.TrimPrefix(row[3], "*main."),
+			HasError: row[4] == "true",
+			Error:    row[5],
+		}
+
+		// Files written before the cid and operation columns were added lack them.
+		if len(row) > 8 {
+			r.CID = row[7]
+			r.Operation = row[8]
+		}
+		if len(row) > 9 {
+			r.ErrorClass = ErrorClass(row[9])
+		}
+
+		extra := row[6]
+		switch r.Type {
+		case "DialStart", "DialEnd", "ConnectEnd", "SecurityStart", "MuxerStart":
+			r.Maddr = extra
+		case "SecurityEnd", "MuxerEnd":
+			if extra != "" {
+				r.Protocols = []string{extra}
+			}
+		case "OpenStreamStart", "OpenStreamEnd", "OpenedStream", "ClosedStream":
+			r.Protocols = strings.Split(extra, ",")
+		case "SendRequestStart", "SendRequestEnd", "SendMessageStart":
+			if extra != "" {
+				r.Message = &MessageSummary{Type: extra}
+			}
+		case "DiscoveredPeer":
+			r.DiscoveredDistance = extra
+		case "LookupDone":
+			if extra != "" {
+				r.Peers = strings.Split(extra, ",")
+			}
+		case "RetrievalQuery", "ProviderFound":
+			r.Target = extra
+		}
+
+		if err = fn(r); err != nil {
+			return err
+		}
+	}
+}
+
+// jsonlRecordWriter writes one JSON object per line.
+type jsonlRecordWriter struct {
+	wc  io.WriteCloser
+	bw  *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLRecordWriter(filename string) (*jsonlRecordWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "create events file")
+	}
+
+	return newJSONLStreamWriter(f), nil
+}
+
+// newJSONLStreamWriter writes JSON lines encoded records to wc.
+func newJSONLStreamWriter(wc io.WriteCloser) *jsonlRecordWriter {
+	bw := bufio.NewWriter(wc)
+	return &jsonlRecordWriter{wc: wc, bw: bw, enc: json.NewEncoder(bw)}
+}
+
+func (jw *jsonlRecordWriter) Write(r *EventRecord) error {
+	return jw.enc.Encode(r)
+}
+
+// Close flushes the buffered records and closes the underlying writer even
+// if the flush failed. The first error is returned.
+func (jw *jsonlRecordWriter) Close() error {
+	err := jw.bw.Flush()
+	if cerr := jw.wc.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func forEachJSONLRecord(filename string, fn func(r *EventRecord) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "open events file")
+	}
+	defer f.Close()
+
+	return decodeJSONLRecords(f, fn)
+}
+
+// decodeJSONLRecords decodes JSON lines encoded records from rd.
+func decodeJSONLRecords(rd io.Reader, fn func(r *EventRecord) error) error {
+	dec := json.NewDecoder(bufio.NewReader(rd))
+	for {
+		r := &EventRecord{}
+		if err := dec.Decode(r); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "decode record")
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+}
+
+// parquetEventRecord is the flat columnar representation of an EventRecord.
+type parquetEventRecord struct {
+	PeerID              string   `parquet:"name=peer_id, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Distance            string   `parquet:"name=distance, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CID                 string   `parquet:"name=cid, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Operation           string   `parquet:"name=operation, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Time                float64  `parquet:"name=time, type=DOUBLE"`
+	Timestamp           int64    `parquet:"name=timestamp_ns, type=INT64"`
+	Type                string   `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HasError            bool     `parquet:"name=has_error, type=BOOLEAN"`
+	Error               string   `parquet:"name=error, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ErrorClass          string   `parquet:"name=error_class, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Transport           string   `parquet:"name=transport, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Maddr               string   `parquet:"name=maddr, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Protocols           []string `parquet:"name=protocols, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	MessageType         string   `parquet:"name=message_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	MessageKey          string   `parquet:"name=message_key, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MessageClusterLevel int32    `parquet:"name=message_cluster_level, type=INT32"`
+	MessageHasRecord    bool     `parquet:"name=message_has_record, type=BOOLEAN"`
+	CloserPeers         []string `parquet:"name=closer_peers, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	ProviderPeers       []string `parquet:"name=provider_peers, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	Discovered          string   `parquet:"name=discovered, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DiscoveredDistance  string   `parquet:"name=discovered_distance, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Peers               []string `parquet:"name=peers, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	PeersSent           int32    `parquet:"name=peers_sent, type=INT32"`
+	Round               int32    `parquet:"name=round, type=INT32"`
+	Target              string   `parquet:"name=target, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Hops                int32    `parquet:"name=hops, type=INT32"`
+	PeersQueried        int32    `parquet:"name=peers_queried, type=INT32"`
+	Duration            float64  `parquet:"name=duration_s, type=DOUBLE"`
+}
+
+func newParquetEventRecord(r *EventRecord) *parquetEventRecord {
+	pr := &parquetEventRecord{
+		PeerID:             r.PeerID,
+		Distance:           r.Distance,
+		CID:                r.CID,
+		Operation:          r.Operation,
+		Time:               r.Time,
+		Timestamp:          r.Timestamp,
+		Type:               r.Type,
+		HasError:           r.HasError,
+		Error:              r.Error,
+		ErrorClass:         string(r.ErrorClass),
+		Transport:          r.Transport,
+		Maddr:              r.Maddr,
+		Protocols:          r.Protocols,
+		Discovered:         r.Discovered,
+		DiscoveredDistance: r.DiscoveredDistance,
+		Peers:              r.Peers,
+		PeersSent:          int32(r.PeersSent),
+		Round:              int32(r.Round),
+		Target:             r.Target,
+		Hops:               int32(r.Hops),
+		PeersQueried:       int32(r.PeersQueried),
+		Duration:           r.Duration,
+	}
+
+	if r.Message != nil {
+		pr.MessageType = r.Message.Type
+		pr.MessageKey = r.Message.Key
+		pr.MessageClusterLevel = r.Message.ClusterLevel
+		pr.MessageHasRecord = r.Message.HasRecord
+		for _, p := range r.Message.CloserPeers {
+			pr.CloserPeers = append(pr.CloserPeers, p.ID)
+		}
+		for _, p := range r.Message.ProviderPeers {
+			pr.ProviderPeers = append(pr.ProviderPeers, p.ID)
+		}
+	}
+
+	return pr
+}
+
+func (pr *parquetEventRecord) EventRecord() *EventRecord {
+	r := &EventRecord{
+		PeerID:             pr.PeerID,
+		Distance:           pr.Distance,
+		CID:                pr.CID,
+		Operation:          pr.Operation,
+		Time:               pr.Time,
+		Timestamp:          pr.Timestamp,
+		Type:               pr.Type,
+		HasError:           pr.HasError,
+		Error:              pr.Error,
+		ErrorClass:         ErrorClass(pr.ErrorClass),
+		Transport:          pr.Transport,
+		Maddr:              pr.Maddr,
+		Protocols:          pr.Protocols,
+		Discovered:         pr.Discovered,
+		DiscoveredDistance: pr.DiscoveredDistance,
+		Peers:              pr.Peers,
+		PeersSent:          int(pr.PeersSent),
+		Round:              int(pr.Round),
+		Target:             pr.Target,
+		Hops:               int(pr.Hops),
+		PeersQueried:       int(pr.PeersQueried),
+		Duration:           pr.Duration,
+	}
+
+	if pr.MessageType != "" {
+		r.Message = &MessageSummary{
+			Type:         pr.MessageType,
+			Key:          pr.MessageKey,
+			ClusterLevel: pr.MessageClusterLevel,
+			HasRecord:    pr.MessageHasRecord,
+		}
+		for _, id := range pr.CloserPeers {
+			r.Message.CloserPeers = append(r.Message.CloserPeers, PeerSummary{ID: id})
+		}
+		for _, id := range pr.ProviderPeers {
+			r.Message.ProviderPeers = append(r.Message.ProviderPeers, PeerSummary{ID: id})
+		}
+	}
+
+	return r
+}
+
+// parquetRecordWriter writes records into a columnar parquet file.
+type parquetRecordWriter struct {
+	f  source.ParquetFile
+	pw *writer.ParquetWriter
+}
+
+func newParquetRecordWriter(filename string) (*parquetRecordWriter, error) {
+	f, err := local.NewLocalFileWriter(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "create events file")
+	}
+
+	pw, err := writer.NewParquetWriter(f, new(parquetEventRecord), 1)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "new parquet writer")
+	}
+
+	return &parquetRecordWriter{f: f, pw: pw}, nil
+}
+
+func (pw *parquetRecordWriter) Write(r *EventRecord) error {
+	return pw.pw.Write(newParquetEventRecord(r))
+}
+
+func (pw *parquetRecordWriter) Close() error {
+	if err := pw.pw.WriteStop(); err != nil {
+		_ = pw.f.Close()
+		return errors.Wrap(err, "write parquet footer")
+	}
+	return pw.f.Close()
+}
+
+// parquetReadBatch is the number of rows that are read from a parquet file at once.
+const parquetReadBatch = 1000
+
+func forEachParquetRecord(filename string, fn func(r *EventRecord) error) error {
+	f, err := local.NewLocalFileReader(filename)
+	if err != nil {
+		return errors.Wrap(err, "open events file")
+	}
+	defer f.Close()
+
+	pr, err := reader.NewParquetReader(f, new(parquetEventRecord), 1)
+	if err != nil {
+		return errors.Wrap(err, "new parquet reader")
+	}
+	defer pr.ReadStop()
+
+	for remaining := int(pr.GetNumRows()); remaining > 0; remaining -= parquetReadBatch {
+		size := parquetReadBatch
+		if remaining < size {
+			size = remaining
+		}
+
+		batch := make([]parquetEventRecord, size)
+		if err = pr.Read(&batch); err != nil {
+			return errors.Wrap(err, "read parquet rows")
+		}
+
+		for i := range batch {
+			if err = fn(batch[i].EventRecord()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
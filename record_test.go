@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+func TestEventRecordRoundTrip(t *testing.T) {
+	content, err := NewRandomContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	peerID := test.RandPeerIDFatal(t)
+	closest := []peer.ID{test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)}
+	base := func(offset time.Duration) BaseEvent {
+		return BaseEvent{ID: peerID, Time: start.Add(offset), CID: content.cid, Operation: "provide-1"}
+	}
+
+	events := []Event{
+		&DialStart{BaseEvent: base(time.Millisecond), Transport: "tcp", Maddr: ma.StringCast("/ip4/1.2.3.4/tcp/4001")},
+		&DialEnd{
+			BaseEvent: base(2 * time.Millisecond),
+			Transport: "tcp",
+			Maddr:     ma.StringCast("/ip4/1.2.3.4/tcp/4001"),
+			Err:       errors.Wrap(syscall.ECONNREFUSED, "dial"),
+		},
+		&SendRequestStart{BaseEvent: base(3 * time.Millisecond), Request: pb.NewMessage(pb.Message_FIND_NODE, content.mhash, 0)},
+		&LookupDone{BaseEvent: base(4 * time.Millisecond), ClosestPeers: closest},
+		&SecurityEnd{BaseEvent: base(5 * time.Millisecond), Transport: "tcp", Maddr: ma.StringCast("/ip4/1.2.3.4/tcp/4001"), Protocol: "/noise"},
+	}
+
+	var want []*EventRecord
+	for _, evt := range events {
+		want = append(want, NewEventRecord(evt, content, start))
+	}
+
+	for _, format := range []string{FormatCSV, FormatJSONL, FormatParquet} {
+		t.Run(format, func(t *testing.T) {
+			filename := EventsFilename(t.TempDir(), format)
+
+			w, err := NewEventRecordWriter(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range want {
+				if err = w.Write(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []*EventRecord
+			err = ForEachEventRecord(filename, func(r *EventRecord) error {
+				got = append(got, r)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(want) {
+				t.Fatalf("read %d records, want %d", len(got), len(want))
+			}
+
+			for i := range want {
+				g, w := got[i], want[i]
+				if g.PeerID != w.PeerID || g.Distance != w.Distance || g.Type != w.Type {
+					t.Errorf("record %d: got %s/%s/%s, want %s/%s/%s", i, g.PeerID, g.Distance, g.Type, w.PeerID, w.Distance, w.Type)
+				}
+				if math.Abs(g.Time-w.Time) > 1e-6 {
+					t.Errorf("record %d: time = %f, want %f", i, g.Time, w.Time)
+				}
+				if g.HasError != w.HasError || g.Error != w.Error || g.ErrorClass != w.ErrorClass {
+					t.Errorf("record %d: error = %v/%q/%q, want %v/%q/%q", i, g.HasError, g.Error, g.ErrorClass, w.HasError, w.Error, w.ErrorClass)
+				}
+				if g.CID != w.CID || g.Operation != w.Operation {
+					t.Errorf("record %d: cid/operation = %s/%s, want %s/%s", i, g.CID, g.Operation, w.CID, w.Operation)
+				}
+				// The extra column of CSV files holds the protocol instead of the address.
+				if g.Maddr != w.Maddr && !(format == FormatCSV && len(w.Protocols) > 0) {
+					t.Errorf("record %d: maddr = %q, want %q", i, g.Maddr, w.Maddr)
+				}
+				if len(g.Peers) != len(w.Peers) || len(g.Protocols) != len(w.Protocols) {
+					t.Errorf("record %d: peers/protocols = %v/%v, want %v/%v", i, g.Peers, g.Protocols, w.Peers, w.Protocols)
+				}
+				if (g.Message == nil) != (w.Message == nil) || (g.Message != nil && g.Message.Type != w.Message.Type) {
+					t.Errorf("record %d: message = %+v, want %+v", i, g.Message, w.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestEventsFilenameFormat(t *testing.T) {
+	for _, format := range []string{FormatCSV, FormatJSONL, FormatParquet} {
+		got, err := formatFromFilename(EventsFilename("out", format))
+		if err != nil {
+			t.Errorf("formatFromFilename(%s): %s", format, err)
+		} else if got != format {
+			t.Errorf("formatFromFilename(%s) = %s", format, got)
+		}
+	}
+
+	if _, err := formatFromFilename(filepath.Join("out", "events.xml")); err == nil {
+		t.Errorf("expected error for unsupported format")
+	}
+}
+
+// failingWriteCloser fails every write and records whether it was closed.
+type failingWriteCloser struct {
+	closed bool
+}
+
+func (f *failingWriteCloser) Write(p []byte) (int, error) {
+	return 0, syscall.ENOSPC
+}
+
+func (f *failingWriteCloser) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestJSONLRecordWriterCloseAfterFailedFlush(t *testing.T) {
+	wc := &failingWriteCloser{}
+	w := newJSONLStreamWriter(wc)
+	if err := w.Write(&EventRecord{Type: "DialStart"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("Close() = %v, want %v", err, syscall.ENOSPC)
+	}
+	if !wc.closed {
+		t.Errorf("underlying writer wasn't closed after the flush failed")
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// spanKind describes how a start and an end event of the same peer
+// are paired to a span in the timeline of that peer.
+type spanKind struct {
+	Name  string
+	Label string
+	Color string
+	Start string
+	Ends  []string
+}
+
+// spanKinds lists all operations that are visualized in the report.
+var spanKinds = []spanKind{
+	{Name: "dial", Label: "Dialing peer", Color: "#d62728", Start: "DialStart", Ends: []string{"DialEnd", "ConnectedEvent"}},
+	{Name: "connect", Label: "Connecting socket", Color: "#e377c2", Start: "DialStart", Ends: []string{"ConnectEnd", "DialEnd"}},
+	{Name: "security", Label: "Security handshake", Color: "#bcbd22", Start: "SecurityStart", Ends: []string{"SecurityEnd"}},
+	{Name: "muxer", Label: "Muxer negotiation", Color: "#7f7f7f", Start: "MuxerStart", Ends: []string{"MuxerEnd"}},
+	{Name: "stream", Label: "Opening stream", Color: "#2ca02c", Start: "OpenStreamStart", Ends: []string{"OpenStreamEnd"}},
+	{Name: "request", Label: "Finding closer nodes", Color: "#177eef", Start: "SendRequestStart", Ends: []string{"SendRequestEnd"}},
+	{Name: "message", Label: "Adding provider", Color: "#9467bd", Start: "SendMessageStart", Ends: []string{"SendMessageEnd"}},
+	{Name: "monitor", Label: "Monitoring provider", Color: "#000000", Start: "MonitorProviderStart", Ends: []string{"MonitorProviderEnd"}},
+	{Name: "lookup", Label: "Lookup phase", Color: "#ff7f0e", Start: "ProvideStart", Ends: []string{"LookupDone"}},
+	{Name: "add_provider", Label: "ADD_PROVIDER phase", Color: "#8c564b", Start: "AddProviderStart", Ends: []string{"ProvideEnd"}},
+	{Name: "retrieval", Label: "FindProviders lookup", Color: "#17becf", Start: "RetrievalStart", Ends: []string{"RetrievalEnd"}},
+}
+
+// Span is a completed operation with a single peer.
+type Span struct {
+	Kind     int
+	Start    float64
+	End      float64
+	HasError bool
+	Error    string
+	Extra    string
+}
+
+// Duration returns the length of the span in seconds.
+func (s *Span) Duration() float64 {
+	return s.End - s.Start
+}
+
+// PeerTimeline holds all spans of a single peer.
+type PeerTimeline struct {
+	PeerID   string
+	Distance string
+
+	// NormDistance is the XOR distance to the content normed to [0, 1).
+	NormDistance float64
+
+	Spans []*Span
+}
+
+// spanState tracks the currently open operations of a certain kind with a peer.
+type spanState struct {
+	start   float64
+	counter int
+}
+
+// Report is the result of analyzing an events file.
+type Report struct {
+	Source string
+
+	// Peers are ordered by their XOR distance to the content.
+	Peers []*PeerTimeline
+
+	Start float64
+	End   float64
+
+	EventCounts map[string]int
+	ErrorCounts map[string]int
+
+	// ErrorClasses holds the number of errors by event type and error class.
+	ErrorClasses map[string]map[ErrorClass]int
+
+	// Durations holds the distribution of span durations in seconds by span kind name.
+	Durations map[string]Distribution
+
+	// TimeToRecord is the distribution of the times at which
+	// the monitored peers first returned the provider record.
+	TimeToRecord Distribution
+}
+
+// NewReport reads the given events file and pairs the start and end
+// events of every peer to spans.
+func NewReport(filename string) (*Report, error) {
+	var records []*EventRecord
+	err := ForEachEventRecord(filename, func(r *EventRecord) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time < records[j].Time
+	})
+
+	rep := &Report{
+		Source:       filename,
+		EventCounts:  map[string]int{},
+		ErrorCounts:  map[string]int{},
+		ErrorClasses: map[string]map[ErrorClass]int{},
+		Durations:    map[string]Distribution{},
+	}
+
+	if len(records) > 0 {
+		rep.Start = records[0].Time
+		rep.End = records[len(records)-1].Time
+	}
+
+	// An event may start or end spans of multiple kinds, e.g. a
+	// DialStart opens the dial as well as the connect span.
+	starts := map[string][]int{}
+	ends := map[string][]int{}
+	for i, kind := range spanKinds {
+		starts[kind.Start] = append(starts[kind.Start], i)
+		for _, end := range kind.Ends {
+			ends[end] = append(ends[end], i)
+		}
+	}
+
+	timelines := map[string]*PeerTimeline{}
+	states := make([]map[string]*spanState, len(spanKinds))
+	for i := range states {
+		states[i] = map[string]*spanState{}
+	}
+
+	recordTimes := map[string]float64{}
+	for _, r := range records {
+		rep.EventCounts[r.Type] += 1
+		if r.HasError {
+			rep.ErrorCounts[r.Type] += 1
+			if _, found := rep.ErrorClasses[r.Type]; !found {
+				rep.ErrorClasses[r.Type] = map[ErrorClass]int{}
+			}
+			rep.ErrorClasses[r.Type][r.ErrorClass] += 1
+		}
+
+		tl, found := timelines[r.PeerID]
+		if !found {
+			tl = &PeerTimeline{
+				PeerID:       r.PeerID,
+				Distance:     r.Distance,
+				NormDistance: normDistance(r.Distance),
+			}
+			timelines[r.PeerID] = tl
+		}
+
+		if r.Type == "MonitorProviderEnd" && !r.HasError {
+			if _, found := recordTimes[r.PeerID]; !found {
+				recordTimes[r.PeerID] = r.Time
+			}
+		}
+
+		// Concurrent operations with the same peer (e.g. providing
+		// and monitoring) don't belong to the same span.
+		key := r.PeerID + r.CID + r.Operation
+
+		if kinds, ok := starts[r.Type]; ok {
+			for _, kind := range kinds {
+				if state, found := states[kind][key]; found {
+					state.counter += 1
+				} else {
+					states[kind][key] = &spanState{start: r.Time, counter: 1}
+				}
+			}
+			continue
+		}
+
+		for _, kind := range ends[r.Type] {
+			state, found := states[kind][key]
+			if !found {
+				continue
+			}
+			state.counter -= 1
+
+			// If there are parallel operations with the same peer
+			// (e.g. dials to multiple addresses) wait for the others
+			// if this one has failed.
+			if r.HasError && state.counter > 0 {
+				continue
+			}
+
+			tl.Spans = append(tl.Spans, &Span{
+				Kind:     kind,
+				Start:    state.start,
+				End:      r.Time,
+				HasError: r.HasError,
+				Error:    r.Error,
+				Extra:    r.Extra(),
+			})
+			delete(states[kind], key)
+		}
+	}
+
+	for _, tl := range timelines {
+		rep.Peers = append(rep.Peers, tl)
+	}
+	sort.Slice(rep.Peers, func(i, j int) bool {
+		return rep.Peers[i].Distance < rep.Peers[j].Distance
+	})
+
+	for i, kind := range spanKinds {
+		var durations []float64
+		for _, tl := range rep.Peers {
+			for _, span := range tl.Spans {
+				if span.Kind == i && !span.HasError {
+					durations = append(durations, span.Duration())
+				}
+			}
+		}
+		rep.Durations[kind.Name] = NewDistribution(durations)
+	}
+
+	var times []float64
+	for _, t := range recordTimes {
+		times = append(times, t)
+	}
+	rep.TimeToRecord = NewDistribution(times)
+
+	return rep, nil
+}
+
+// normDistance converts the given hex encoded 256 bit XOR
+// distance to a value between 0 and 1.
+func normDistance(distance string) float64 {
+	d, ok := new(big.Int).SetString(distance, 16)
+	if !ok {
+		return 0
+	}
+	norm, _ := new(big.Float).Quo(new(big.Float).SetInt(d), new(big.Float).SetMantExp(big.NewFloat(1), 256)).Float64()
+	return norm
+}
+
+// The dimensions of the timeline in pixels.
+const (
+	reportLabelWidth = 200
+	reportPlotWidth  = 1000
+	reportRowHeight  = 40
+	reportAxisHeight = 30
+)
+
+// reportView is the data that is passed to the HTML template.
+type reportView struct {
+	*Report
+	Kinds     []spanKind
+	Width     int
+	Height    int
+	RowHeight int
+	Rows      []reportRow
+	Ticks     []reportTick
+	Stats     []reportStat
+}
+
+type reportRow struct {
+	Y            int
+	Label        string
+	PeerID       string
+	NormDistance float64
+	Striped      bool
+	Lines        []reportLine
+}
+
+type reportLine struct {
+	X1, X2, Y float64
+	Color     string
+	Opacity   float64
+	Title     string
+}
+
+type reportTick struct {
+	X     float64
+	Label string
+}
+
+type reportStat struct {
+	Name string
+	Distribution
+}
+
+// WriteHTML renders the report as a self-contained HTML page with an SVG timeline.
+func (rep *Report) WriteHTML(filename string) error {
+	view := &reportView{
+		Report: rep,
+		Kinds:  spanKinds,
+		Width:  reportLabelWidth + reportPlotWidth + 20,
+		Height: reportAxisHeight + len(rep.Peers)*reportRowHeight,
+
+		RowHeight: reportRowHeight,
+	}
+
+	period := rep.End - rep.Start
+	if period <= 0 {
+		period = 1
+	}
+	xPos := func(t float64) float64 {
+		return reportLabelWidth + (t-rep.Start)/period*reportPlotWidth
+	}
+
+	step := tickStep(period)
+	for t := math.Ceil(rep.Start/step) * step; t <= rep.End; t += step {
+		if math.Abs(t) < step/2 {
+			t = 0 // avoid labels like -0s or 1e-17s
+		}
+		view.Ticks = append(view.Ticks, reportTick{X: xPos(t), Label: fmt.Sprintf("%.3gs", t)})
+	}
+
+	for i, tl := range rep.Peers {
+		row := reportRow{
+			Y:            reportAxisHeight + i*reportRowHeight,
+			Label:        shortString(tl.PeerID, 16),
+			PeerID:       tl.PeerID,
+			NormDistance: tl.NormDistance,
+			Striped:      i%2 == 0,
+		}
+
+		for _, span := range tl.Spans {
+			kind := spanKinds[span.Kind]
+			line := reportLine{
+				X1:      xPos(span.Start),
+				X2:      math.Max(xPos(span.End), xPos(span.Start)+1),
+				Y:       float64(row.Y) + 4 + float64(span.Kind)*3,
+				Color:   kind.Color,
+				Opacity: 1,
+				Title: fmt.Sprintf("%s\nStart: %.5fs\nEnd: %.5fs\nDuration: %s\n||XOR||: %.4e\nPeer ID: %s",
+					kind.Label, span.Start, span.End, formatSeconds(span.Duration()), tl.NormDistance, tl.PeerID),
+			}
+			if span.Extra != "" {
+				line.Title += "\nExtra: " + span.Extra
+			}
+			if span.HasError {
+				line.Opacity = 0.25
+				line.Title += "\nError: " + span.Error
+			}
+			row.Lines = append(row.Lines, line)
+		}
+
+		view.Rows = append(view.Rows, row)
+	}
+
+	for _, kind := range spanKinds {
+		view.Stats = append(view.Stats, reportStat{Name: kind.Label, Distribution: rep.Durations[kind.Name]})
+	}
+	view.Stats = append(view.Stats, reportStat{Name: "Time until record is returned", Distribution: rep.TimeToRecord})
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrap(err, "create report file")
+	}
+
+	if err = reportTemplate.Execute(f, view); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "render report")
+	}
+	return errors.Wrap(f.Close(), "close report file")
+}
+
+// tickStep returns a step of 1, 2 or 5 times a power of ten
+// that splits the given period into roughly ten ticks.
+func tickStep(period float64) float64 {
+	raw := period / 10
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatSeconds formats durations below one second in milliseconds.
+func formatSeconds(s float64) string {
+	if s >= 1 {
+		return fmt.Sprintf("%.3fs", s)
+	}
+	return fmt.Sprintf("%.1fms", s*1000)
+}
+
+// shortString truncates s to at most n characters.
+func shortString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms": func(s float64) string { return fmt.Sprintf("%.1f", s*1000) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>DHT provide measurement - {{.Source}}</title>
+<style>
+  body { font-family: sans-serif; font-size: 13px; margin: 20px; }
+  table { border-collapse: collapse; margin-bottom: 20px; }
+  th, td { padding: 2px 10px; text-align: right; }
+  th:first-child, td:first-child { text-align: left; }
+  tr:nth-child(even) { background: #f2f2f2; }
+  .legend span { display: inline-block; margin-right: 15px; }
+  .legend i { display: inline-block; width: 20px; height: 4px; margin-right: 5px; vertical-align: middle; }
+  svg text { font-family: monospace; font-size: 11px; }
+</style>
+</head>
+<body>
+<h1>DHT provide measurement</h1>
+<p>{{.Source}} &middot; {{len .Peers}} peers &middot; {{printf "%.3f" .Start}}s - {{printf "%.3f" .End}}s</p>
+
+<h2>Durations</h2>
+<table>
+  <tr><th>Operation</th><th>Count</th><th>Min [ms]</th><th>Median [ms]</th><th>Mean [ms]</th><th>P90 [ms]</th><th>Max [ms]</th></tr>
+  {{- range .Stats}}
+  <tr><td>{{.Name}}</td><td>{{.Count}}</td><td>{{ms .Min}}</td><td>{{ms .Median}}</td><td>{{ms .Mean}}</td><td>{{ms .P90}}</td><td>{{ms .Max}}</td></tr>
+  {{- end}}
+</table>
+
+<h2>Timeline</h2>
+<p>Peers are ordered by their XOR distance to the content. Faded spans have failed.</p>
+<div class="legend">
+  {{- range .Kinds}}
+  <span><i style="background: {{.Color}}"></i>{{.Label}}</span>
+  {{- end}}
+</div>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+  {{- range .Ticks}}
+  <line x1="{{.X}}" x2="{{.X}}" y1="25" y2="{{$.Height}}" stroke="#cccccc" stroke-dasharray="2,2"/>
+  <text x="{{.X}}" y="18" text-anchor="middle">{{.Label}}</text>
+  {{- end}}
+  {{- range .Rows}}
+  {{- if .Striped}}
+  <rect x="0" y="{{.Y}}" width="{{$.Width}}" height="{{$.RowHeight}}" fill="#000000" fill-opacity="0.05"/>
+  {{- end}}
+  <text x="5" y="{{.Y}}" dy="19"><title>{{.PeerID}} ||XOR||: {{printf "%.4e" .NormDistance}}</title>{{.Label}}</text>
+  {{- range .Lines}}
+  <line x1="{{.X1}}" x2="{{.X2}}" y1="{{.Y}}" y2="{{.Y}}" stroke="{{.Color}}" stroke-opacity="{{.Opacity}}" stroke-width="3"><title>{{.Title}}</title></line>
+  {{- end}}
+  {{- end}}
+</svg>
+
+<h2>Events</h2>
+<table>
+  <tr><th>Type</th><th>Count</th><th>Errors</th></tr>
+  {{- range $type, $count := .EventCounts}}
+  <tr><td>{{$type}}</td><td>{{$count}}</td><td>{{index $.ErrorCounts $type}}</td></tr>
+  {{- end}}
+</table>
+
+{{- if .ErrorClasses}}
+<h2>Errors</h2>
+<table>
+  <tr><th>Type</th><th>Class</th><th>Count</th></tr>
+  {{- range $type, $classes := .ErrorClasses}}
+  {{- range $class, $count := $classes}}
+  <tr><td>{{$type}}</td><td>{{$class}}</td><td>{{$count}}</td></tr>
+  {{- end}}
+  {{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// PeerBreakdown holds the span durations of a group of peers,
+// e.g. all peers that run the same client.
+type PeerBreakdown struct {
+	Group string
+	Peers int
+
+	// Durations holds the distribution of the span durations by span kind name.
+	Durations map[string]Distribution
+}
+
+// DurationsBy groups the span durations of all peers by the value that
+// the given function returns for their metadata, e.g. the agent version.
+// Peers without metadata or with an empty value are grouped under "unknown".
+func (rep *Report) DurationsBy(peers map[string]*PeerMetadata, group func(pm *PeerMetadata) string) []*PeerBreakdown {
+	samples := map[string][][]float64{}
+	counts := map[string]int{}
+	for _, tl := range rep.Peers {
+		name := "unknown"
+		if pm, found := peers[tl.PeerID]; found && group(pm) != "" {
+			name = group(pm)
+		}
+
+		if _, found := samples[name]; !found {
+			samples[name] = make([][]float64, len(spanKinds))
+		}
+		counts[name] += 1
+
+		for _, span := range tl.Spans {
+			if !span.HasError {
+				samples[name][span.Kind] = append(samples[name][span.Kind], span.Duration())
+			}
+		}
+	}
+
+	var breakdowns []*PeerBreakdown
+	for name, durations := range samples {
+		bd := &PeerBreakdown{
+			Group:     name,
+			Peers:     counts[name],
+			Durations: map[string]Distribution{},
+		}
+		for i, kind := range spanKinds {
+			bd.Durations[kind.Name] = NewDistribution(durations[i])
+		}
+		breakdowns = append(breakdowns, bd)
+	}
+
+	sort.Slice(breakdowns, func(i, j int) bool {
+		if breakdowns[i].Peers != breakdowns[j].Peers {
+			return breakdowns[i].Peers > breakdowns[j].Peers
+		}
+		return breakdowns[i].Group < breakdowns[j].Group
+	})
+
+	return breakdowns
+}
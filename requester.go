@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"sync"
 	"time"
 
@@ -10,7 +9,6 @@ import (
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
-	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/libp2p/go-libp2p-core/routing"
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
@@ -18,6 +16,10 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrNoProviderRecord is the error of MonitorProviderEnd events
+// if the peer responded but didn't return a provider record.
+var ErrNoProviderRecord = errors.New("not found")
+
 type Requester struct {
 	h   host.Host
 	dht *kaddht.IpfsDHT
@@ -25,45 +27,57 @@ type Requester struct {
 	eh  *EventHub
 }
 
-func NewRequester(ctx context.Context, eh *EventHub) (*Requester, error) {
-	// Generate a new identity
-	key, _, err := crypto.GenerateKeyPair(crypto.Secp256k1, 256)
-	if err != nil {
-		return nil, errors.Wrap(err, "generate key pair")
-	}
-
-	// Initialize a new libp2p host with the above identity
-	var dht *kaddht.IpfsDHT
+// NewRequester constructs a requester whose host has the given identity.
+func NewRequester(ctx context.Context, key crypto.PrivKey, eh *EventHub) (*Requester, error) {
+	// Initialize a new libp2p host with the given identity
+	var (
+		dht *kaddht.IpfsDHT
+		pm  *pb.ProtocolMessenger
+		err error
+	)
 	h, err := libp2p.New(ctx,
 		libp2p.Identity(key),
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			dht, err = kaddht.New(ctx, h)
+			dht, pm, err = newDHT(ctx, h, nil)
 			return dht, err
 		}))
 	if err != nil {
 		return nil, errors.Wrap(err, "new libp2p host")
 	}
 
-	ms := &messageSenderImpl{
-		host:      h,
-		protocols: protocol.ConvertFromStrings([]string{"/ipfs/kad/1.0.0"}),
-		strmap:    make(map[peer.ID]*peerMessageSender),
+	return newRequester(h, dht, pm, eh), nil
+}
+
+// NewSimulatedRequester constructs a requester whose host has the
+// given identity and is part of the given simulated DHT network.
+func NewSimulatedRequester(ctx context.Context, sim *Simulation, key crypto.PrivKey, eh *EventHub) (*Requester, error) {
+	h, err := sim.NewHost(key, eh)
+	if err != nil {
+		return nil, errors.Wrap(err, "new simulated host")
 	}
 
-	pm, err := pb.NewProtocolMessenger(ms)
+	dht, pm, err := newDHT(ctx, h, nil, kaddht.BootstrapPeers())
 	if err != nil {
 		return nil, err
 	}
+
+	return newRequester(h, dht, pm, eh), nil
+}
+
+// newRequester assembles a requester. The requests of its DHT aren't
+// recorded. The given protocol messenger is used to ask the closest
+// peers for provider records.
+func newRequester(h host.Host, dht *kaddht.IpfsDHT, pm *pb.ProtocolMessenger, eh *EventHub) *Requester {
 	return &Requester{
 		h:   h,
 		dht: dht,
 		pm:  pm,
 		eh:  eh,
-	}, nil
+	}
 }
 
-func (r *Requester) Bootstrap(ctx context.Context) error {
-	for _, bp := range kaddht.GetDefaultBootstrapPeerAddrInfos() {
+func (r *Requester) Bootstrap(ctx context.Context, peers []peer.AddrInfo) error {
+	for _, bp := range peers {
 		log.WithField("type", "requester").Infoln("Connecting to bootstrap peer")
 		if err := r.h.Connect(ctx, bp); err != nil {
 			return errors.Wrap(err, "connecting to bootstrap peer")
@@ -72,44 +86,68 @@ func (r *Requester) Bootstrap(ctx context.Context) error {
 	return nil
 }
 
-func (r *Requester) MonitorProviders(ctx context.Context, content *Content) error {
+// MonitorProviders looks up the closest peers to the given content and
+// periodically asks each of them for provider records until it returns one.
+// The returned channel is closed when all peers have returned a record,
+// failed, or the context was cancelled.
+func (r *Requester) MonitorProviders(ctx context.Context, content *Content, interval time.Duration) (<-chan struct{}, error) {
 	logEntry := log.WithField("type", "requester")
+	ctx = WithContent(ctx, content)
 
 	logEntry.Infoln("Getting closest peers")
-	closest, err := r.dht.GetClosestPeers(ctx, string(content.cid.Hash()))
+	closest, err := r.dht.GetClosestPeers(WithOperation(ctx, "lookup"), string(content.cid.Hash()))
 	if err != nil {
-		return errors.Wrap(err, "get closest peers")
+		return nil, errors.Wrap(err, "get closest peers")
 	}
 	logEntry.Infof("Found %d peers", len(closest))
 
 	logEntry.Infof("Starting monitoring")
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
+
 		logEntry.Infoln("Querying closest peers for provider records")
 		var wg sync.WaitGroup
 		for _, c := range closest {
-			log.WithField("targetID", c.Pretty()[:16]).Infoln("Mark as relevant")
+			log.WithField("targetID", shortPeerID(c)).Infoln("Mark as relevant")
 			r.eh.MarkAsRelevant(c)
 			wg.Add(1)
 			go func(peerID peer.ID) {
 				defer wg.Done()
 
-				logEntry2 := logEntry.WithField("targetID", peerID.Pretty()[:16]).WithField("count", len(closest))
+				// Every monitored peer is a separate operation.
+				ctx := WithOperation(ctx, "monitor")
+
+				logEntry2 := logEntry.WithField("targetID", shortPeerID(peerID)).WithField("count", len(closest))
+
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ticker.C:
+					case <-ctx.Done():
+						return
+					}
 
-				for range time.Tick(time.Millisecond * 500) {
 					logEntry2.Infoln("Getting providers...")
 
 					r.eh.PushEvent(&MonitorProviderStart{
 						BaseEvent: BaseEvent{
-							ID:   peerID,
-							Time: time.Now(),
+							ID:        peerID,
+							Time:      time.Now(),
+							CID:       content.cid,
+							Operation: OperationIDFromContext(ctx),
 						},
 					})
 
 					provs, _, err := r.pm.GetProviders(ctx, peerID, content.mhash)
 					eevent := &MonitorProviderEnd{
 						BaseEvent: BaseEvent{
-							ID:   peerID,
-							Time: time.Now(),
+							ID:        peerID,
+							Time:      time.Now(),
+							CID:       content.cid,
+							Operation: OperationIDFromContext(ctx),
 						},
 					}
 					if err != nil {
@@ -123,14 +161,51 @@ func (r *Requester) MonitorProviders(ctx context.Context, content *Content) erro
 						return
 					}
 
-					eevent.Err = fmt.Errorf("not found")
+					eevent.Err = ErrNoProviderRecord
 					r.eh.PushEvent(eevent)
 				}
 			}(c)
 		}
 		wg.Wait()
-		log.Infoln("All peers returned the provider record!")
+		log.Infoln("Stopped monitoring provider records")
 	}()
 
-	return nil
+	return done, nil
+}
+
+// RoutingTableSnapshot captures the current routing table of the given role
+// with the distances of the peers relative to the given content.
+func (r *Requester) RoutingTableSnapshot(role string, phase string, content *Content) *RoutingTableSnapshot {
+	return NewRoutingTableSnapshot(role, phase, r.h, r.dht, content)
+}
+
+// RestoreState restores the routing table and peerstore that a previous
+// run saved for the given role (e.g. "retriever") from the state
+// directory. It returns the number of restored peers.
+func (r *Requester) RestoreState(conf *Config, role string) (int, error) {
+	return restoreState(conf, role, r.h, r.dht)
+}
+
+// SaveState saves the routing table and peerstore of the
+// given role to the state directory.
+func (r *Requester) SaveState(conf *Config, role string) error {
+	return saveState(conf, role, r.h, r.dht)
+}
+
+// Close shuts down the DHT and the libp2p host of the requester.
+func (r *Requester) Close() error {
+	if err := r.dht.Close(); err != nil {
+		return errors.Wrap(err, "close dht")
+	}
+	return r.h.Close()
+}
+
+// shortPeerID returns the first 16 characters of the
+// base58 encoded peer ID for logging purposes.
+func shortPeerID(peerID peer.ID) string {
+	pretty := peerID.Pretty()
+	if len(pretty) > 16 {
+		return pretty[:16]
+	}
+	return pretty
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	log "github.com/sirupsen/logrus"
+)
+
+// MonitorRetrieval performs a FindProviders lookup for the given content
+// right away and then every interval until the context is cancelled. A lookup
+// starts no earlier than the previous one has finished. The returned channel
+// is closed after the last lookup has finished.
+func (r *Requester) MonitorRetrieval(ctx context.Context, content *Content, interval time.Duration) <-chan struct{} {
+	// The retrieval events carry our own peer ID.
+	r.eh.MarkAsRelevant(r.h.ID())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for round := 1; ; round++ {
+			if err := r.FindProviders(ctx, content, round); err != nil && ctx.Err() == nil {
+				log.WithError(err).WithField("round", round).Warnln("Could not find providers")
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return done
+}
+
+// FindProviders performs a full FindProvidersAsync lookup for the given
+// content and records the queried peers, the depth of the lookup and the
+// time until the first provider was found as events.
+func (r *Requester) FindProviders(ctx context.Context, content *Content, round int) error {
+	logEntry := log.WithField("type", "requester").WithField("round", round)
+
+	ctx, cancel := context.WithCancel(WithOperation(WithContent(ctx, content), "retrieval"))
+	defer cancel()
+
+	ctx, queryEvents := routing.RegisterForQueryEvents(ctx)
+
+	start := time.Now()
+	r.eh.PushEvent(&RetrievalStart{
+		BaseEvent: BaseEvent{ID: r.h.ID(), Time: start, CID: content.cid, Operation: OperationIDFromContext(ctx)},
+		Round:     round,
+	})
+
+	// hops tracks how many steps it took to discover a peer. Peers
+	// from our own routing table are one hop away.
+	hops := map[peer.ID]int{}
+	maxHop := 0
+	queried := 0
+
+	logEntry.Infoln("Finding providers...")
+	provs := r.dht.FindProvidersAsync(ctx, content.cid, 0)
+	for provs != nil {
+		select {
+		case evt, ok := <-queryEvents:
+			if !ok {
+				queryEvents = nil
+				continue
+			}
+
+			switch evt.Type {
+			case routing.SendingQuery:
+				hop, found := hops[evt.ID]
+				if !found {
+					hop = 1
+					hops[evt.ID] = hop
+				}
+				if hop > maxHop {
+					maxHop = hop
+				}
+				queried += 1
+
+				r.eh.PushEvent(&RetrievalQuery{
+					BaseEvent: BaseEvent{ID: r.h.ID(), Time: time.Now(), CID: content.cid, Operation: OperationIDFromContext(ctx)},
+					Round:     round,
+					Target:    evt.ID,
+					Hop:       hop,
+				})
+			case routing.PeerResponse:
+				for _, resp := range evt.Responses {
+					if _, found := hops[resp.ID]; !found {
+						hops[resp.ID] = hops[evt.ID] + 1
+					}
+				}
+			}
+		case prov, ok := <-provs:
+			if !ok {
+				provs = nil
+				continue
+			}
+
+			logEntry.WithField("providerID", shortPeerID(prov.ID)).Infoln("Found provider")
+			now := time.Now()
+			r.eh.PushEvent(&ProviderFound{
+				BaseEvent: BaseEvent{ID: r.h.ID(), Time: now, CID: content.cid, Operation: OperationIDFromContext(ctx)},
+				Round:     round,
+				Provider:  prov.ID,
+				Hops:      maxHop,
+				Duration:  now.Sub(start),
+			})
+		}
+	}
+
+	end := time.Now()
+	r.eh.PushEvent(&RetrievalEnd{
+		BaseEvent:    BaseEvent{ID: r.h.ID(), Time: end, CID: content.cid, Operation: OperationIDFromContext(ctx)},
+		Round:        round,
+		Hops:         maxHop,
+		PeersQueried: queried,
+		Duration:     end.Sub(start),
+		Err:          ctx.Err(),
+	})
+
+	return ctx.Err()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	log "github.com/sirupsen/logrus"
+)
+
+// bucketSize is the number of peers a bucket of the routing table can hold.
+const bucketSize = 20
+
+// RoutingTableStats describes the size and the bucket occupancy of a routing table.
+type RoutingTableStats struct {
+	Size int `json:"size"`
+
+	// BucketOccupancy holds the number of peers by their common
+	// prefix length with the local peer ID. The slice ends with
+	// the largest common prefix length of any peer.
+	BucketOccupancy []int `json:"bucket_occupancy"`
+
+	// FullBuckets is the number of consecutive full buckets
+	// starting at common prefix length 0.
+	FullBuckets int `json:"full_buckets"`
+}
+
+// NewRoutingTableStats computes the statistics of a routing table of the
+// given local peer from a snapshot of its peers, e.g. rt.ListPeers().
+// A single snapshot is used because the routing table may change
+// while the buckets are counted.
+func NewRoutingTableStats(local peer.ID, peers []peer.ID) *RoutingTableStats {
+	rts := &RoutingTableStats{
+		Size:            len(peers),
+		BucketOccupancy: []int{},
+	}
+
+	localID := kbucket.ConvertPeerID(local)
+	for _, p := range peers {
+		cpl := kbucket.CommonPrefixLen(localID, kbucket.ConvertPeerID(p))
+		for len(rts.BucketOccupancy) <= cpl {
+			rts.BucketOccupancy = append(rts.BucketOccupancy, 0)
+		}
+		rts.BucketOccupancy[cpl] += 1
+	}
+
+	for _, n := range rts.BucketOccupancy {
+		if n < bucketSize {
+			break
+		}
+		rts.FullBuckets += 1
+	}
+
+	return rts
+}
+
+// WarmupResult describes a warm-up phase of a routing table.
+type WarmupResult struct {
+	Duration  float64 `json:"duration_s"`
+	Refreshes int     `json:"refreshes"`
+
+	// Reached is false if the warm-up stopped before
+	// the routing table met the requested criteria.
+	Reached bool `json:"reached"`
+}
+
+// WarmUp refreshes the routing table of the given DHT until it holds at
+// least minPeers peers and the buckets of the fullBuckets lowest common
+// prefix lengths are full, or until the timeout has passed.
+func WarmUp(ctx context.Context, dht *kaddht.IpfsDHT, minPeers int, fullBuckets int, timeout time.Duration) *WarmupResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.WithField("minPeers", minPeers).WithField("fullBuckets", fullBuckets).Infoln("Warming up routing table")
+
+	start := time.Now()
+	wr := &WarmupResult{}
+	for {
+		rts := NewRoutingTableStats(dht.PeerID(), dht.RoutingTable().ListPeers())
+		logEntry := log.WithField("size", rts.Size).WithField("fullBuckets", rts.FullBuckets)
+		if rts.Size >= minPeers && rts.FullBuckets >= fullBuckets {
+			logEntry.WithField("refreshes", wr.Refreshes).Infoln("Routing table is warm")
+			wr.Reached = true
+			break
+		}
+		logEntry.Debugln("Refreshing routing table")
+
+		select {
+		case err := <-dht.ForceRefresh():
+			if err != nil && ctx.Err() == nil {
+				log.WithError(err).Warnln("Could not refresh routing table")
+				sleepCtx(ctx, time.Second)
+			}
+			wr.Refreshes += 1
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			logEntry.WithField("refreshes", wr.Refreshes).Warnln("Routing table warm-up timed out")
+			break
+		}
+	}
+	wr.Duration = time.Since(start).Seconds()
+
+	return wr
+}
+
+// RoutingTableSnapshot captures the routing table of a host at a
+// certain phase of the measurement, e.g. right before the provide.
+type RoutingTableSnapshot struct {
+	// Role is the role of the host, e.g. "provider" or "requester".
+	Role      string `json:"role"`
+	PeerID    string `json:"peer_id"`
+	Phase     string `json:"phase"`
+	Timestamp int64  `json:"timestamp_ns"`
+
+	Peers []*RoutingTableEntry `json:"peers"`
+}
+
+// RoutingTableEntry is a single peer of a routing table snapshot. The
+// timestamps are unix nanoseconds and omitted if the event never happened.
+type RoutingTableEntry struct {
+	PeerID string `json:"peer_id"`
+
+	// Bucket is the common prefix length with the local peer ID.
+	Bucket int `json:"bucket"`
+
+	// Distance is the XOR distance to the measured content. It is
+	// omitted if the snapshot isn't taken for a single content.
+	Distance string `json:"distance,omitempty"`
+
+	AddedAt                int64 `json:"added_at_ns,omitempty"`
+	LastUsefulAt           int64 `json:"last_useful_at_ns,omitempty"`
+	LastSuccessfulOutbound int64 `json:"last_successful_outbound_query_at_ns,omitempty"`
+
+	// Latency is the moving average of the round trip time
+	// from the peerstore. It is omitted if it's unknown.
+	Latency float64 `json:"latency_s,omitempty"`
+}
+
+// NewRoutingTableSnapshot captures the current routing table of the given
+// host. The distances of the peers are relative to the given content.
+func NewRoutingTableSnapshot(role string, phase string, h host.Host, dht *kaddht.IpfsDHT, content *Content) *RoutingTableSnapshot {
+	rts := &RoutingTableSnapshot{
+		Role:      role,
+		PeerID:    h.ID().Pretty(),
+		Phase:     phase,
+		Timestamp: time.Now().UnixNano(),
+		Peers:     []*RoutingTableEntry{},
+	}
+
+	local := kbucket.ConvertPeerID(h.ID())
+	for _, pi := range dht.RoutingTable().GetPeerInfos() {
+		rts.Peers = append(rts.Peers, &RoutingTableEntry{
+			PeerID:                 pi.Id.Pretty(),
+			Bucket:                 kbucket.CommonPrefixLen(local, kbucket.ConvertPeerID(pi.Id)),
+			Distance:               distance(pi.Id, content),
+			AddedAt:                unixNano(pi.AddedAt),
+			LastUsefulAt:           unixNano(pi.LastUsefulAt),
+			LastSuccessfulOutbound: unixNano(pi.LastSuccessfulOutboundQueryAt),
+			Latency:                h.Peerstore().LatencyEWMA(pi.Id).Seconds(),
+		})
+	}
+
+	sort.Slice(rts.Peers, func(i, j int) bool {
+		return rts.Peers[i].Distance < rts.Peers[j].Distance
+	})
+
+	return rts
+}
+
+// unixNano returns the unix nanoseconds of the given time or 0 if it's the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+)
+
+func TestNewRoutingTableStats(t *testing.T) {
+	local := test.RandPeerIDFatal(t)
+	localID := kbucket.ConvertPeerID(local)
+
+	// peersWithCpls generates peers with the given common prefix lengths.
+	peersWithCpls := func(cpls ...int) []peer.ID {
+		var peers []peer.ID
+		for _, cpl := range cpls {
+			for {
+				p := test.RandPeerIDFatal(t)
+				if kbucket.CommonPrefixLen(localID, kbucket.ConvertPeerID(p)) == cpl {
+					peers = append(peers, p)
+					break
+				}
+			}
+		}
+		return peers
+	}
+
+	full := make([]int, bucketSize)
+	fullAndOne := append(make([]int, bucketSize), 1)
+
+	tests := []struct {
+		name        string
+		peers       []peer.ID
+		occupancy   []int
+		fullBuckets int
+	}{
+		{name: "empty", peers: nil, occupancy: []int{}, fullBuckets: 0},
+		{name: "gap", peers: peersWithCpls(0, 0, 2), occupancy: []int{2, 0, 1}, fullBuckets: 0},
+		{name: "full bucket", peers: peersWithCpls(full...), occupancy: []int{bucketSize}, fullBuckets: 1},
+		{name: "full bucket and more", peers: peersWithCpls(fullAndOne...), occupancy: []int{bucketSize, 1}, fullBuckets: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rts := NewRoutingTableStats(local, tt.peers)
+			if rts.Size != len(tt.peers) {
+				t.Errorf("Size = %d, want %d", rts.Size, len(tt.peers))
+			}
+			if !reflect.DeepEqual(rts.BucketOccupancy, tt.occupancy) {
+				t.Errorf("BucketOccupancy = %v, want %v", rts.BucketOccupancy, tt.occupancy)
+			}
+			if rts.FullBuckets != tt.fullBuckets {
+				t.Errorf("FullBuckets = %d, want %d", rts.FullBuckets, tt.fullBuckets)
+			}
+		})
+	}
+}
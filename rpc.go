@@ -20,8 +20,10 @@ import (
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
 
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p-kad-dht/metrics"
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/pkg/errors"
 )
 
 var dhtReadMessageTimeout = 10 * time.Second
@@ -41,6 +43,44 @@ type messageSenderImpl struct {
 	eventHub  *EventHub
 }
 
+// newMessageSender constructs a message sender for the given DHT protocols
+// that dispatches the events of all requests and messages to the given event
+// hub, which may be nil. The DHT drops the streams to peers it disconnects
+// from by calling OnDisconnect.
+func newMessageSender(h host.Host, protos []protocol.ID, eh *EventHub) *messageSenderImpl {
+	return &messageSenderImpl{
+		host:      h,
+		protocols: protos,
+		strmap:    make(map[peer.ID]*peerMessageSender),
+		eventHub:  eh,
+	}
+}
+
+// newDHT constructs a DHT whose requests and messages are all sent through an
+// instrumented message sender. The dials of the DHT are attributed to the
+// operations they were made for. It also returns a protocol messenger that uses
+// the same message sender, so that requests sent through it reuse the streams
+// of the DHT like kad-dht's own protocol messenger does.
+func newDHT(ctx context.Context, h host.Host, eh *EventHub, opts ...kaddht.Option) (*kaddht.IpfsDHT, *pb.ProtocolMessenger, error) {
+	var ms *messageSenderImpl
+	opts = append(opts, kaddht.MessageSenderImpl(func(h host.Host, protos []protocol.ID) pb.MessageSender {
+		ms = newMessageSender(h, protos, eh)
+		return ms
+	}))
+
+	dht, err := kaddht.New(ctx, newAttributingHost(h, eh), opts...)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "new dht")
+	}
+
+	pm, err := pb.NewProtocolMessenger(ms)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "new protocol messenger")
+	}
+
+	return dht, pm, nil
+}
+
 func NewMessageSenderImpl(h host.Host, protos []protocol.ID) pb.MessageSender {
 	return &messageSenderImpl{
 		host:      h,
@@ -73,14 +113,18 @@ func (m *messageSenderImpl) OnDisconnect(ctx context.Context, p peer.ID) {
 func (m *messageSenderImpl) SendRequest(ctx context.Context, p peer.ID, pmes *pb.Message) (*pb.Message, error) {
 	m.eventHub.PushEvent(&SendRequestStart{
 		BaseEvent: BaseEvent{
-			ID:   p,
-			Time: time.Now(),
+			ID:        p,
+			Time:      time.Now(),
+			CID:       ContentIDFromContext(ctx),
+			Operation: OperationIDFromContext(ctx),
 		},
 		Request: pmes,
 	})
 	endEvent := &SendRequestEnd{
 		BaseEvent: BaseEvent{
-			ID: p,
+			ID:        p,
+			CID:       ContentIDFromContext(ctx),
+			Operation: OperationIDFromContext(ctx),
 		},
 	}
 	defer func() {
@@ -90,8 +134,10 @@ func (m *messageSenderImpl) SendRequest(ctx context.Context, p peer.ID, pmes *pb
 			for _, pi := range pb.PBPeersToPeerInfos(endEvent.Response.CloserPeers) {
 				m.eventHub.PushEvent(&DiscoveredPeer{
 					BaseEvent: BaseEvent{
-						ID:   p,
-						Time: time.Now(),
+						ID:        p,
+						Time:      time.Now(),
+						CID:       ContentIDFromContext(ctx),
+						Operation: OperationIDFromContext(ctx),
 					},
 					Discovered: pi.ID,
 				})
@@ -139,14 +185,18 @@ func (m *messageSenderImpl) SendRequest(ctx context.Context, p peer.ID, pmes *pb
 func (m *messageSenderImpl) SendMessage(ctx context.Context, p peer.ID, pmes *pb.Message) error {
 	m.eventHub.PushEvent(&SendMessageStart{
 		BaseEvent: BaseEvent{
-			ID:   p,
-			Time: time.Now(),
+			ID:        p,
+			Time:      time.Now(),
+			CID:       ContentIDFromContext(ctx),
+			Operation: OperationIDFromContext(ctx),
 		},
 		Message: pmes,
 	})
 	endEvent := &SendMessageEnd{
 		BaseEvent: BaseEvent{
-			ID: p,
+			ID:        p,
+			CID:       ContentIDFromContext(ctx),
+			Operation: OperationIDFromContext(ctx),
 		},
 	}
 	defer func() {
@@ -256,14 +306,18 @@ func (ms *peerMessageSender) prepOrInvalidate(ctx context.Context) error {
 func (ms *peerMessageSender) prep(ctx context.Context) error {
 	ms.eh.PushEvent(&OpenStreamStart{
 		BaseEvent: BaseEvent{
-			ID:   ms.p,
-			Time: time.Now(),
+			ID:        ms.p,
+			Time:      time.Now(),
+			CID:       ContentIDFromContext(ctx),
+			Operation: OperationIDFromContext(ctx),
 		},
 		Protocols: ms.m.protocols,
 	})
 	endEvent := &OpenStreamEnd{
 		BaseEvent: BaseEvent{
-			ID: ms.p,
+			ID:        ms.p,
+			CID:       ContentIDFromContext(ctx),
+			Operation: OperationIDFromContext(ctx),
 		},
 		Protocols: ms.m.protocols,
 	}
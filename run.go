@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RunManifest describes a single measurement run. It is written
+// next to the events of that run and used to build the aggregate
+// summary after all runs have finished.
+type RunManifest struct {
+	Run           int    `json:"run"`
+	Dir           string `json:"dir"`
+	CID           string `json:"cid"`
+	ProviderID    string `json:"provider_id"`
+	RequesterID   string `json:"requester_id"`
+	RetrieverID   string `json:"retriever_id,omitempty"`
+	ProviderCPL   int    `json:"provider_cpl"`
+	RequesterCPL  int    `json:"requester_cpl"`
+	RestoredPeers int    `json:"restored_peers"`
+
+	// Transports are the names of the transports the provider dialed
+	// with. Simulations only use the "sim" transport of the mocknet.
+	Transports []string `json:"transports,omitempty"`
+
+	// Warmup describes the warm-up phase of the routing table of the
+	// provider. It is null if no warm-up was configured.
+	Warmup *WarmupResult `json:"warmup"`
+
+	// RoutingTable describes the routing table of the provider right
+	// before the content was provided.
+	RoutingTable    *RoutingTableStats `json:"routing_table"`
+	StartedAt       time.Time          `json:"started_at"`
+	FinishedAt      time.Time          `json:"finished_at"`
+	MonitorInterval float64            `json:"monitor_interval_s"`
+	GracePeriod     float64            `json:"grace_period_s"`
+	ProvideDuration float64            `json:"provide_duration_s"`
+	MonitoredPeers  int                `json:"monitored_peers"`
+	PeersWithRecord int                `json:"peers_with_record"`
+
+	// Errors holds the number of recorded errors by error class.
+	Errors map[ErrorClass]int `json:"errors,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// NewRunManifest initializes a manifest for the given run and
+// creates a timestamped directory for its output.
+func NewRunManifest(conf *Config, run int) (*RunManifest, error) {
+	now := time.Now()
+	rm := &RunManifest{
+		Run:             run,
+		Dir:             filepath.Join(conf.OutDir, fmt.Sprintf("%s_run-%03d", now.Format("20060102T150405"), run)),
+		StartedAt:       now,
+		MonitorInterval: conf.MonitorInterval.Seconds(),
+		GracePeriod:     conf.GracePeriod.Seconds(),
+	}
+
+	rm.Transports = conf.Transports
+	if conf.Simulate {
+		rm.Transports = []string{"sim"}
+	}
+
+	if err := os.MkdirAll(rm.Dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "create run directory")
+	}
+
+	return rm, nil
+}
+
+// CountMonitorResults derives the number of monitored peers and the number
+// of peers that returned a provider record from the given events file. It
+// also counts the errors of all recorded events by their class except for
+// the monitor polls that didn't return a record.
+func (rm *RunManifest) CountMonitorResults(filename string) error {
+	monitored := map[string]bool{}
+	rm.Errors = map[ErrorClass]int{}
+	err := ForEachEventRecord(filename, func(r *EventRecord) error {
+		// Polls of peers that don't have the record (yet) are no failures.
+		if r.HasError && r.ErrorClass != ErrorClassNotFound {
+			rm.Errors[r.ErrorClass] += 1
+		}
+
+		switch r.Type {
+		case "MonitorProviderStart":
+			if _, found := monitored[r.PeerID]; !found {
+				monitored[r.PeerID] = false
+			}
+		case "MonitorProviderEnd":
+			monitored[r.PeerID] = monitored[r.PeerID] || !r.HasError
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rm.MonitoredPeers = len(monitored)
+	for _, found := range monitored {
+		if found {
+			rm.PeersWithRecord += 1
+		}
+	}
+
+	return nil
+}
+
+// Save writes the manifest as JSON into the run directory.
+func (rm *RunManifest) Save() error {
+	return writeJSON(filepath.Join(rm.Dir, "manifest.json"), rm)
+}
+
+// Manifest describes the setup of a provide-batch or persistence
+// measurement. It is written next to the events into the output directory.
+type Manifest struct {
+	Command     string `json:"command"`
+	CID         string `json:"cid,omitempty"`
+	ProviderID  string `json:"provider_id,omitempty"`
+	RequesterID string `json:"requester_id,omitempty"`
+
+	// Transports are the names of the transports the provider dialed
+	// with. Simulations only use the "sim" transport of the mocknet.
+	Transports []string `json:"transports,omitempty"`
+
+	// Warmup describes the warm-up phase of the routing table of the
+	// provider. It is null if no warm-up was configured or nothing
+	// was provided.
+	Warmup *WarmupResult `json:"warmup"`
+
+	// RoutingTable describes the routing table of the provider right
+	// before the first content was provided.
+	RoutingTable *RoutingTableStats `json:"routing_table"`
+	StartedAt    time.Time          `json:"started_at"`
+}
+
+// NewManifest initializes the manifest of the given command.
+func NewManifest(conf *Config, command string) *Manifest {
+	m := &Manifest{
+		Command:    command,
+		Transports: conf.Transports,
+		StartedAt:  time.Now(),
+	}
+	if conf.Simulate {
+		m.Transports = []string{"sim"}
+	}
+	return m
+}
+
+// Save writes the manifest as JSON into the given directory.
+func (m *Manifest) Save(dir string) error {
+	return writeJSON(filepath.Join(dir, "manifest.json"), m)
+}
+
+// Succeeded returns true if the run finished without an error.
+func (rm *RunManifest) Succeeded() bool {
+	return rm.Error == ""
+}
+
+// AggregateSummary summarizes multiple measurement runs.
+type AggregateSummary struct {
+	Runs             int          `json:"runs"`
+	FailedRuns       int          `json:"failed_runs"`
+	ProvideDuration  Distribution `json:"provide_duration_s"`
+	PeersWithRecord  Distribution `json:"peers_with_record"`
+	RecordShareRatio Distribution `json:"record_share_ratio"`
+
+	// Errors holds the number of recorded errors of all
+	// successful runs by error class.
+	Errors    map[ErrorClass]int `json:"errors"`
+	Manifests []string           `json:"manifests"`
+}
+
+// NewAggregateSummary computes summary statistics over all successful runs.
+func NewAggregateSummary(manifests []*RunManifest) *AggregateSummary {
+	as := &AggregateSummary{
+		Runs:      len(manifests),
+		Errors:    map[ErrorClass]int{},
+		Manifests: []string{},
+	}
+
+	var durations, peers, ratios []float64
+	for _, rm := range manifests {
+		as.Manifests = append(as.Manifests, filepath.Join(rm.Dir, "manifest.json"))
+		if !rm.Succeeded() {
+			as.FailedRuns += 1
+			continue
+		}
+		for class, count := range rm.Errors {
+			as.Errors[class] += count
+		}
+		durations = append(durations, rm.ProvideDuration)
+		peers = append(peers, float64(rm.PeersWithRecord))
+		if rm.MonitoredPeers > 0 {
+			ratios = append(ratios, float64(rm.PeersWithRecord)/float64(rm.MonitoredPeers))
+		}
+	}
+
+	as.ProvideDuration = NewDistribution(durations)
+	as.PeersWithRecord = NewDistribution(peers)
+	as.RecordShareRatio = NewDistribution(ratios)
+
+	return as
+}
+
+// Save writes the aggregate summary as JSON to the given directory.
+func (as *AggregateSummary) Save(dir string) error {
+	return writeJSON(filepath.Join(dir, "summary.json"), as)
+}
+
+// Distribution holds descriptive statistics of a list of samples.
+type Distribution struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std_dev"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+}
+
+// NewDistribution computes descriptive statistics of the given samples.
+func NewDistribution(samples []float64) Distribution {
+	d := Distribution{Count: len(samples)}
+	if len(samples) == 0 {
+		return d
+	}
+
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, s := range sorted {
+		sum += s
+	}
+	d.Mean = sum / float64(len(sorted))
+
+	variance := 0.0
+	for _, s := range sorted {
+		variance += (s - d.Mean) * (s - d.Mean)
+	}
+	d.StdDev = math.Sqrt(variance / float64(len(sorted)))
+
+	d.Min = sorted[0]
+	d.Max = sorted[len(sorted)-1]
+	d.Median = quantile(sorted, 0.5)
+	d.P90 = quantile(sorted, 0.9)
+
+	return d
+}
+
+// quantile returns the q-quantile of the given sorted samples
+// by linearly interpolating between the closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+// writeJSON writes the given value as indented JSON to filename.
+func writeJSON(filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal json")
+	}
+	return errors.Wrap(os.WriteFile(filename, data, 0o644), "write json")
+}
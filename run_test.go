@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []float64
+		want    Distribution
+	}{
+		{
+			name:    "empty",
+			samples: nil,
+			want:    Distribution{},
+		},
+		{
+			name:    "single",
+			samples: []float64{2},
+			want:    Distribution{Count: 1, Min: 2, Max: 2, Mean: 2, Median: 2, P90: 2},
+		},
+		{
+			name:    "even",
+			samples: []float64{4, 1, 3, 2},
+			want:    Distribution{Count: 4, Min: 1, Max: 4, Mean: 2.5, StdDev: math.Sqrt(1.25), Median: 2.5, P90: 3.7},
+		},
+		{
+			name:    "odd",
+			samples: []float64{10, 0, 5, 5, 5},
+			want:    Distribution{Count: 5, Min: 0, Max: 10, Mean: 5, StdDev: math.Sqrt(10), Median: 5, P90: 8},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewDistribution(tt.samples)
+			if got.Count != tt.want.Count ||
+				!approx(got.Min, tt.want.Min) ||
+				!approx(got.Max, tt.want.Max) ||
+				!approx(got.Mean, tt.want.Mean) ||
+				!approx(got.StdDev, tt.want.StdDev) ||
+				!approx(got.Median, tt.want.Median) ||
+				!approx(got.P90, tt.want.P90) {
+				t.Errorf("NewDistribution(%v) = %+v, want %+v", tt.samples, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDistributionKeepsSamples(t *testing.T) {
+	samples := []float64{3, 1, 2}
+	NewDistribution(samples)
+	if samples[0] != 3 || samples[1] != 1 || samples[2] != 2 {
+		t.Errorf("NewDistribution reordered the samples: %v", samples)
+	}
+}
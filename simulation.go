@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// simConnections is the number of random other nodes each
+// simulated DHT node connects to before refreshing its routing table.
+const simConnections = 8
+
+// simBootstrapPeers is the number of simulated DHT nodes
+// that are handed out as bootstrap peers.
+const simBootstrapPeers = 4
+
+// simRetransmissions is the number of times the lost handshake of
+// a simulated dial is retransmitted before the dial fails.
+const simRetransmissions = 3
+
+// simRetransmissionTimeout is the time after which the lost handshake of a
+// simulated dial is first retransmitted. It doubles with every retransmission.
+const simRetransmissionTimeout = time.Second
+
+// Simulation is an in-process network of kad-dht server nodes that are
+// connected via a libp2p mocknet. It allows running measurements without
+// access to the live IPFS network.
+type Simulation struct {
+	mn         mocknet.Mocknet
+	nodes      []*kaddht.IpfsDHT
+	hostsLk    sync.Mutex
+	hosts      map[peer.ID]struct{}
+	profile    *NetworkProfile
+	conditions map[peer.ID]PeerConditions
+	cancel     context.CancelFunc
+}
+
+// NewSimulationFromConfig spins up the simulated network that is configured
+// by the user. It returns nil if no simulation was requested. The network
+// conditions of the simulated peers are written to the output directory.
+func NewSimulationFromConfig(ctx context.Context, conf *Config) (*Simulation, error) {
+	if !conf.Simulate {
+		return nil, nil
+	}
+
+	var profile *NetworkProfile
+	if conf.SimProfile != "" {
+		var err error
+		if profile, err = LoadNetworkProfile(conf.SimProfile); err != nil {
+			return nil, err
+		}
+	}
+
+	sim, err := NewSimulation(ctx, conf.SimNodes, profile)
+	if err != nil {
+		return nil, errors.Wrap(err, "new simulation")
+	}
+
+	if profile != nil {
+		if err = sim.SaveConditions(filepath.Join(conf.OutDir, "conditions.csv")); err != nil {
+			sim.Close()
+			return nil, err
+		}
+	}
+
+	return sim, nil
+}
+
+// NewSimulation spins up the given number of DHT server nodes, connects
+// each of them to a few random other nodes and refreshes their routing tables.
+// If a network profile is given, every node is assigned latency, loss and
+// bandwidth according to that profile. The mocknet only lets peers dial each
+// other if they are linked, so the number of links grows quadratically with
+// the number of nodes.
+func NewSimulation(ctx context.Context, n int, profile *NetworkProfile) (*Simulation, error) {
+	if n <= simConnections {
+		return nil, fmt.Errorf("simulation needs more than %d nodes", simConnections)
+	}
+
+	log.WithField("nodes", n).Infoln("Starting simulated DHT network")
+
+	// The mocknet shuts down all of its hosts when its context is cancelled.
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Simulation{
+		mn:         mocknet.New(ctx),
+		nodes:      make([]*kaddht.IpfsDHT, n),
+		hosts:      map[peer.ID]struct{}{},
+		profile:    profile,
+		conditions: map[peer.ID]PeerConditions{},
+		cancel:     cancel,
+	}
+
+	for i := 0; i < n; i++ {
+		h, err := s.mn.GenPeer()
+		if err != nil {
+			s.Close()
+			return nil, errors.Wrap(err, "generate peer")
+		}
+
+		if profile != nil {
+			cond := profile.SampleConditions()
+			s.conditions[h.ID()] = cond
+			if cond.Loss > 0 {
+				h = &lossyHost{Host: h, loss: cond.Loss}
+			}
+		}
+
+		dht, err := kaddht.New(ctx, h, kaddht.Mode(kaddht.ModeServer), kaddht.BootstrapPeers())
+		if err != nil {
+			s.Close()
+			return nil, errors.Wrap(err, "new dht")
+		}
+		s.nodes[i] = dht
+		s.hosts[h.ID()] = struct{}{}
+	}
+
+	for i := range s.nodes {
+		for j := i + 1; j < len(s.nodes); j++ {
+			if err := s.linkPeers(s.nodes[i].Host().ID(), s.nodes[j].Host().ID()); err != nil {
+				s.Close()
+				return nil, err
+			}
+		}
+	}
+
+	for i, node := range s.nodes {
+		for _, j := range rand.Perm(n)[:simConnections] {
+			if i == j {
+				continue
+			}
+			if _, err := s.mn.ConnectPeers(node.Host().ID(), s.nodes[j].Host().ID()); err != nil {
+				s.Close()
+				return nil, errors.Wrap(err, "connect peers")
+			}
+		}
+	}
+
+	if err := s.refreshRoutingTables(ctx); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	log.WithField("nodes", n).Infoln("Simulated DHT network is ready")
+	return s, nil
+}
+
+// refreshRoutingTables waits until all nodes have added their connected
+// peers to their routing tables and then refreshes all routing tables.
+func (s *Simulation) refreshRoutingTables(ctx context.Context) error {
+	for _, node := range s.nodes {
+		for node.RoutingTable().Size() == 0 {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(s.nodes))
+	for _, node := range s.nodes {
+		wg.Add(1)
+		go func(node *kaddht.IpfsDHT) {
+			defer wg.Done()
+			if err := <-node.ForceRefresh(); err != nil {
+				errs <- errors.Wrap(err, "refresh routing table")
+			}
+		}(node)
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// NewHost adds a new host with the given identity to the simulated network
+// and links it to all other live hosts. The host isn't connected to anyone
+// yet. Its dials are recorded with the given event hub. Closing the returned
+// host removes it from the simulated network.
+func (s *Simulation) NewHost(key crypto.PrivKey, eh *EventHub) (host.Host, error) {
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "peer id from private key")
+	}
+
+	addr, err := simMultiaddr(id)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := s.mn.AddPeer(key, addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "add peer to mocknet")
+	}
+
+	s.hostsLk.Lock()
+	defer s.hostsLk.Unlock()
+
+	if s.profile != nil && s.profile.Self != "" {
+		s.conditions[h.ID()] = s.profile.Region(s.profile.Self).SampleConditions()
+	}
+
+	for p := range s.hosts {
+		if err = s.linkPeers(h.ID(), p); err != nil {
+			return nil, err
+		}
+	}
+	s.hosts[h.ID()] = struct{}{}
+
+	return &simHost{Host: h, sim: s, eventHub: eh}, nil
+}
+
+// removeHost unlinks the given host from all other hosts and
+// removes it from the routing tables of the simulated DHT nodes.
+// The mocknet doesn't support removing peers altogether.
+func (s *Simulation) removeHost(id peer.ID) error {
+	s.hostsLk.Lock()
+	defer s.hostsLk.Unlock()
+
+	if _, found := s.hosts[id]; !found {
+		return nil
+	}
+	delete(s.hosts, id)
+
+	for p := range s.hosts {
+		if err := s.mn.UnlinkPeers(id, p); err != nil {
+			return errors.Wrap(err, "unlink peers")
+		}
+	}
+
+	for _, node := range s.nodes {
+		node.RoutingTable().RemovePeer(id)
+	}
+
+	return nil
+}
+
+// simHost is a host that was added to the simulation for a single run.
+type simHost struct {
+	host.Host
+	sim      *Simulation
+	eventHub *EventHub
+}
+
+// Connect connects to the given peer in the simulated network. The mocknet
+// establishes connections instantly and has no transports that could record
+// the connection setup. Therefore, a dial to a peer that isn't connected yet
+// takes the round trip time of the link. The handshake is lost with the
+// combined loss probability of both peers and retransmitted with a doubling
+// timeout like a TCP SYN. The dial fails if the last retransmission is lost
+// as well. The dial is recorded with DialStart and DialEnd events of the
+// "sim" transport.
+func (h *simHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	if h.Network().Connectedness(pi.ID) == network.Connected {
+		return h.Host.Connect(ctx, pi)
+	}
+
+	var maddr ma.Multiaddr
+	if len(pi.Addrs) > 0 {
+		maddr = pi.Addrs[0]
+	} else if addrs := h.Peerstore().Addrs(pi.ID); len(addrs) > 0 {
+		maddr = addrs[0]
+	}
+
+	h.eventHub.PushEvent(&DialStart{
+		BaseEvent: BaseEvent{
+			ID:   pi.ID,
+			Time: time.Now(),
+		},
+		Transport: "sim",
+		Maddr:     maddr,
+	})
+
+	rtt, loss := h.sim.linkConditions(h.ID(), pi.ID)
+	delay := rtt
+	var err error
+	for retransmissions := 0; rand.Float64() < loss; retransmissions++ {
+		if retransmissions == simRetransmissions {
+			err = errors.Wrap(swarm.ErrDialTimeout, "simulated packet loss")
+			break
+		}
+		delay += simRetransmissionTimeout << retransmissions
+	}
+
+	sleepCtx(ctx, delay)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = h.Host.Connect(ctx, pi)
+	}
+
+	h.eventHub.PushEvent(&DialEnd{
+		BaseEvent: BaseEvent{
+			ID:   pi.ID,
+			Time: time.Now(),
+		},
+		Transport: "sim",
+		Maddr:     maddr,
+		Err:       err,
+	})
+
+	return err
+}
+
+// Close closes the host and removes it from the simulated network.
+func (h *simHost) Close() error {
+	err := h.Host.Close()
+	if rerr := h.sim.removeHost(h.ID()); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// linkPeers links the two given peers and configures the link according to
+// their network conditions. The latency of the link is the sum of both
+// peer latencies and the bandwidth is limited by the slower peer. The
+// caller must hold hostsLk once the simulation is running.
+func (s *Simulation) linkPeers(a, b peer.ID) error {
+	link, err := s.mn.LinkPeers(a, b)
+	if err != nil {
+		return errors.Wrap(err, "link peers")
+	}
+
+	ca, cb := s.conditions[a], s.conditions[b]
+
+	bandwidth := ca.Bandwidth
+	if bandwidth == 0 || (cb.Bandwidth != 0 && cb.Bandwidth < bandwidth) {
+		bandwidth = cb.Bandwidth
+	}
+
+	link.SetOptions(mocknet.LinkOptions{
+		Latency:   ca.Latency + cb.Latency,
+		Bandwidth: bandwidth,
+	})
+
+	return nil
+}
+
+// linkConditions returns the round trip time and the probability that a
+// packet is lost on the link between the two given peers.
+func (s *Simulation) linkConditions(a, b peer.ID) (time.Duration, float64) {
+	s.hostsLk.Lock()
+	defer s.hostsLk.Unlock()
+
+	ca, cb := s.conditions[a], s.conditions[b]
+	return 2 * (ca.Latency + cb.Latency), 1 - (1-ca.Loss)*(1-cb.Loss)
+}
+
+// SaveConditions writes the network conditions of all simulated
+// peers as CSV to the given file.
+func (s *Simulation) SaveConditions(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrap(err, "create conditions file")
+	}
+
+	s.hostsLk.Lock()
+	defer s.hostsLk.Unlock()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"peer_id", "region", "latency_ms", "loss", "bandwidth"})
+	for peerID, cond := range s.conditions {
+		w.Write([]string{
+			peerID.Pretty(),
+			cond.Region,
+			fmt.Sprintf("%.3f", float64(cond.Latency)/float64(time.Millisecond)),
+			fmt.Sprintf("%.4f", cond.Loss),
+			fmt.Sprintf("%.0f", cond.Bandwidth),
+		})
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "write conditions")
+	}
+	return errors.Wrap(f.Close(), "close conditions file")
+}
+
+// BootstrapPeers returns the addresses of a few random simulated DHT nodes.
+func (s *Simulation) BootstrapPeers() []peer.AddrInfo {
+	var peers []peer.AddrInfo
+	for _, i := range rand.Perm(len(s.nodes))[:simBootstrapPeers] {
+		peers = append(peers, *host.InfoFromHost(s.nodes[i].Host()))
+	}
+	return peers
+}
+
+// Close shuts down all simulated DHT nodes and their hosts.
+func (s *Simulation) Close() {
+	for _, node := range s.nodes {
+		if node != nil {
+			_ = node.Close()
+		}
+	}
+	s.cancel()
+}
+
+// simMultiaddr derives a unique but undialable multi address from the given
+// peer ID. This mirrors what mocknet does for generated peers.
+func simMultiaddr(id peer.ID) (ma.Multiaddr, error) {
+	suffix := id
+	if len(id) > 8 {
+		suffix = id[len(id)-8:]
+	}
+	ip := append(net.IP{}, net.ParseIP("100::")...)
+	copy(ip[net.IPv6len-len(suffix):], suffix)
+	return ma.NewMultiaddr(fmt.Sprintf("/ip6/%s/tcp/4242", ip))
+}
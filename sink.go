@@ -0,0 +1,236 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+)
+
+// EventSink receives all events that are pushed to the EventHub. Calls to
+// Consume are serialized by the hub, so implementations don't need to be
+// safe for concurrent use. Close is called after the hub was stopped.
+type EventSink interface {
+	Consume(event Event) error
+	Close() error
+}
+
+// RelevantEventWriter is implemented by sinks that can write all
+// events of relevant peers after the hub was stopped.
+type RelevantEventWriter interface {
+	WriteRelevant(w EventRecordWriter, content *Content, eh *EventHub) error
+}
+
+// MemorySink keeps all events in memory grouped by peer.
+type MemorySink struct {
+	events map[peer.ID][]Event
+}
+
+var _ RelevantEventWriter = (*MemorySink)(nil)
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{
+		events: map[peer.ID][]Event{},
+	}
+}
+
+func (ms *MemorySink) Consume(event Event) error {
+	ms.events[event.PeerID()] = append(ms.events[event.PeerID()], event)
+	return nil
+}
+
+func (ms *MemorySink) Close() error {
+	return nil
+}
+
+// WriteRelevant writes all events of relevant peers to the given writer.
+func (ms *MemorySink) WriteRelevant(w EventRecordWriter, content *Content, eh *EventHub) error {
+	for peerID, events := range ms.events {
+		if !eh.IsRelevant(peerID) {
+			continue
+		}
+
+		for _, evt := range events {
+			if err := w.Write(NewEventRecord(evt, content, eh.startTime)); err != nil {
+				return errors.Wrap(err, "write record")
+			}
+		}
+	}
+	return nil
+}
+
+// streamSink writes every event as a JSON line to the underlying writer
+// as soon as it is consumed. The records only contain the absolute
+// timestamp as the start of the measurement may not be known yet.
+type streamSink struct {
+	w       EventRecordWriter
+	content *Content
+}
+
+func newStreamSink(wc io.WriteCloser, content *Content) *streamSink {
+	return &streamSink{
+		w:       newJSONLStreamWriter(wc),
+		content: content,
+	}
+}
+
+func (ss *streamSink) Consume(event Event) error {
+	return ss.w.Write(NewEventRecord(event, ss.content, time.Time{}))
+}
+
+func (ss *streamSink) Close() error {
+	return ss.w.Close()
+}
+
+// FileSink streams all events unfiltered to a JSON lines file, so
+// memory consumption stays constant for long measurements.
+type FileSink struct {
+	*streamSink
+	filename string
+}
+
+var _ RelevantEventWriter = (*FileSink)(nil)
+
+// NewFileSink creates the given file and streams all events to it.
+func NewFileSink(filename string, content *Content) (*FileSink, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "create stream file")
+	}
+
+	return &FileSink{
+		streamSink: newStreamSink(f, content),
+		filename:   filename,
+	}, nil
+}
+
+// WriteRelevant reads the streamed events back in and writes those
+// of relevant peers with times relative to the start of the measurement
+// to the given writer.
+func (fs *FileSink) WriteRelevant(w EventRecordWriter, content *Content, eh *EventHub) error {
+	return forEachJSONLRecord(fs.filename, func(r *EventRecord) error {
+		peerID, err := peer.Decode(r.PeerID)
+		if err != nil {
+			return errors.Wrap(err, "decode peer id")
+		}
+
+		if !eh.IsRelevant(peerID) {
+			return nil
+		}
+
+		r.Time = time.Unix(0, r.Timestamp).Sub(eh.startTime).Seconds()
+		if err = w.Write(r); err != nil {
+			return errors.Wrap(err, "write record")
+		}
+		return nil
+	})
+}
+
+const (
+	// networkSinkBuffer is the number of events that are buffered
+	// for the network sink if the exporter doesn't keep up.
+	networkSinkBuffer = 10000
+
+	// networkSinkWriteTimeout is the maximum duration of a single
+	// write to the exporter before the network sink gives up.
+	networkSinkWriteTimeout = 5 * time.Second
+)
+
+// NetworkSink streams all events unfiltered as JSON lines to a remote TCP
+// endpoint. The events are written by a separate goroutine, so a slow
+// exporter doesn't delay the measurement. If the buffer is full, new events
+// are dropped. If a write fails or exceeds the write timeout, the exporter
+// is given up and all remaining events are dropped.
+type NetworkSink struct {
+	conn    net.Conn
+	w       EventRecordWriter
+	content *Content
+	records chan *EventRecord
+	done    chan struct{}
+	dropped *atomic.Int64
+	err     error
+}
+
+// NewNetworkSink connects to the given TCP address and streams all events to it.
+func NewNetworkSink(addr string, content *Content) (*NetworkSink, error) {
+	conn, err := net.DialTimeout("tcp", addr, networkSinkWriteTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial event exporter")
+	}
+
+	ns := &NetworkSink{
+		conn:    conn,
+		w:       newJSONLStreamWriter(&deadlineConn{Conn: conn, timeout: networkSinkWriteTimeout}),
+		content: content,
+		records: make(chan *EventRecord, networkSinkBuffer),
+		done:    make(chan struct{}),
+		dropped: atomic.NewInt64(0),
+	}
+	go ns.export()
+
+	return ns, nil
+}
+
+// export writes the buffered records to the exporter until the sink is closed.
+func (ns *NetworkSink) export() {
+	defer close(ns.done)
+	for r := range ns.records {
+		if ns.err != nil {
+			ns.dropped.Inc()
+			continue
+		}
+
+		if err := ns.w.Write(r); err != nil {
+			log.WithError(err).Warnln("Could not export event, dropping all following events")
+			ns.err = errors.Wrap(err, "export event")
+			ns.dropped.Inc()
+		}
+	}
+}
+
+// Consume buffers the given event for the export. It never blocks.
+func (ns *NetworkSink) Consume(event Event) error {
+	select {
+	case ns.records <- NewEventRecord(event, ns.content, time.Time{}):
+	default:
+		ns.dropped.Inc()
+	}
+	return nil
+}
+
+// Close waits until all buffered events were exported and closes the connection.
+func (ns *NetworkSink) Close() error {
+	close(ns.records)
+	<-ns.done
+
+	if dropped := ns.dropped.Load(); dropped > 0 {
+		log.WithField("dropped", dropped).Warnln("Exporter didn't receive all events")
+	}
+
+	// The writer doesn't close the connection if the final flush fails.
+	err := ns.w.Close()
+	_ = ns.conn.Close()
+
+	if err != nil && ns.err == nil {
+		ns.err = errors.Wrap(err, "close exporter connection")
+	}
+	return ns.err
+}
+
+// deadlineConn sets a deadline before every write to the connection.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (dc *deadlineConn) Write(b []byte) (int, error) {
+	if err := dc.Conn.SetWriteDeadline(time.Now().Add(dc.timeout)); err != nil {
+		return 0, err
+	}
+	return dc.Conn.Write(b)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestNetworkSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lines := make(chan int)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		count := 0
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			count += 1
+		}
+		lines <- count
+	}()
+
+	ns, err := NewNetworkSink(ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerID := test.RandPeerIDFatal(t)
+	maddr := ma.StringCast("/ip4/127.0.0.1/tcp/4001")
+	for i := 0; i < 100; i++ {
+		if err = ns.Consume(&DialStart{BaseEvent: BaseEvent{ID: peerID, Time: time.Now()}, Transport: "tcp", Maddr: maddr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = ns.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := <-lines; count != 100 {
+		t.Errorf("exporter received %d events, want 100", count)
+	}
+}
+
+func TestNetworkSinkStalledExporter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The exporter accepts the connection but never reads.
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(2 * networkSinkWriteTimeout)
+		}
+	}()
+
+	ns, err := NewNetworkSink(ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerID := test.RandPeerIDFatal(t)
+	maddr := ma.StringCast("/ip4/127.0.0.1/tcp/4001")
+	start := time.Now()
+	for i := 0; i < 4*networkSinkBuffer; i++ {
+		_ = ns.Consume(&DialStart{BaseEvent: BaseEvent{ID: peerID, Time: time.Now()}, Transport: "tcp", Maddr: maddr})
+	}
+	if elapsed := time.Since(start); elapsed > networkSinkWriteTimeout {
+		t.Errorf("consuming events took %s, expected it to never block", elapsed)
+	}
+
+	// The buffered events don't fit into the socket buffers,
+	// so the export must time out instead of blocking forever.
+	if err = ns.Close(); err == nil {
+		t.Errorf("expected the export to fail")
+	}
+	if ns.dropped.Load() == 0 {
+		t.Errorf("expected events to be dropped")
+	}
+}
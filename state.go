@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// PeerState is the state of a host that is kept between runs, so
+// that a measurement can start with a warm routing table.
+type PeerState struct {
+	// RoutingTable holds the peers of the routing table of the DHT.
+	RoutingTable []peer.ID `json:"routing_table"`
+
+	// Peerstore holds the known addresses of all peers with at least one address.
+	Peerstore []peer.AddrInfo `json:"peerstore"`
+}
+
+// StateFilename returns the file in the state directory
+// that holds the state of the given kind of the given role.
+func StateFilename(conf *Config, role string, kind string) string {
+	return filepath.Join(conf.StateDir, role+"."+kind)
+}
+
+// NewPeerState captures the routing table and peerstore of the given host.
+func NewPeerState(h host.Host, dht *kaddht.IpfsDHT) *PeerState {
+	ps := &PeerState{
+		RoutingTable: dht.RoutingTable().ListPeers(),
+		Peerstore:    []peer.AddrInfo{},
+	}
+
+	for _, peerID := range h.Peerstore().PeersWithAddrs() {
+		if peerID == h.ID() {
+			continue
+		}
+		ps.Peerstore = append(ps.Peerstore, h.Peerstore().PeerInfo(peerID))
+	}
+
+	return ps
+}
+
+// LoadPeerState reads the state from the given file. It returns
+// nil and no error if the file doesn't exist.
+func LoadPeerState(filename string) (*PeerState, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "read peer state")
+	}
+
+	ps := &PeerState{}
+	if err = json.Unmarshal(data, ps); err != nil {
+		return nil, errors.Wrap(err, "unmarshal peer state")
+	}
+
+	return ps, nil
+}
+
+// Restore adds the addresses to the peerstore of the given host and
+// the peers to the routing table of the given DHT. It returns the
+// number of peers that were added to the routing table.
+func (ps *PeerState) Restore(h host.Host, dht *kaddht.IpfsDHT) int {
+	for _, ai := range ps.Peerstore {
+		h.Peerstore().AddAddrs(ai.ID, ai.Addrs, peerstore.AddressTTL)
+	}
+
+	added := 0
+	for _, peerID := range ps.RoutingTable {
+		ok, err := dht.RoutingTable().TryAddPeer(peerID, true, false)
+		if err != nil {
+			log.WithError(err).WithField("peerID", shortPeerID(peerID)).Debugln("Could not restore routing table peer")
+		} else if ok {
+			added += 1
+		}
+	}
+
+	return added
+}
+
+// Save writes the state as JSON to the given file.
+func (ps *PeerState) Save(filename string) error {
+	return writeJSON(filename, ps)
+}
+
+// restoreState restores the state of the host with the given role from the
+// state directory if one is configured and the role has a saved state. It
+// returns the number of peers that were added to the routing table.
+func restoreState(conf *Config, role string, h host.Host, dht *kaddht.IpfsDHT) (int, error) {
+	if conf.StateDir == "" {
+		return 0, nil
+	}
+
+	ps, err := LoadPeerState(StateFilename(conf, role, "peers.json"))
+	if err != nil || ps == nil {
+		return 0, err
+	}
+
+	added := ps.Restore(h, dht)
+	log.WithField("role", role).WithField("peers", added).Infoln("Restored routing table")
+
+	return added, nil
+}
+
+// saveState writes the state of the host with the given role
+// to the state directory if one is configured.
+func saveState(conf *Config, role string, h host.Host, dht *kaddht.IpfsDHT) error {
+	if conf.StateDir == "" {
+		return nil
+	}
+	return NewPeerState(h, dht).Save(StateFilename(conf, role, "peers.json"))
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// StatsSink aggregates the statistics of the provide operations while the
+// events are consumed. It only keeps counters and the phase times of every
+// provide operation instead of the events themselves, so its memory usage
+// doesn't grow with the number of dials, requests and monitor rounds.
+type StatsSink struct {
+	// provides holds the statistics of the provide operations by the key
+	// of their events (see statsKey). The order slice holds the same
+	// statistics in the order the operations were started.
+	provides map[string]*provideStats
+	order    []*provideStats
+
+	// records maps the monitored peers to the time at which they first
+	// returned the provider record. It is nil if they haven't yet.
+	records map[peer.ID]*time.Time
+
+	retrievals     map[int]*retrievalStats
+	retrievalOrder []*retrievalStats
+
+	// dialed holds the multi address of the last successful dial of every peer.
+	dialed map[peer.ID]ma.Multiaddr
+}
+
+// provideStats holds the counters of a single provide operation.
+type provideStats struct {
+	cid   cid.Cid
+	round int
+
+	start            time.Time
+	lookupDone       time.Time
+	addProviderStart time.Time
+	end              time.Time
+	done             bool
+	err              error
+
+	closestPeers        []peer.ID
+	peersSent           int
+	contacted           map[peer.ID]struct{}
+	findNodeRequests    int
+	findNodeErrors      int
+	addProviderMessages int
+	addProviderErrors   int
+	errors              map[ErrorClass]int
+	dials               map[string]*DialStats
+}
+
+// retrievalStats holds the results of a single FindProviders lookup.
+type retrievalStats struct {
+	summary       *RetrievalSummary
+	firstProvider time.Time
+}
+
+func NewStatsSink() *StatsSink {
+	return &StatsSink{
+		provides:   map[string]*provideStats{},
+		records:    map[peer.ID]*time.Time{},
+		retrievals: map[int]*retrievalStats{},
+		dialed:     map[peer.ID]ma.Multiaddr{},
+	}
+}
+
+// statsKey returns the key of the provide operation the given event belongs
+// to. That's the operation ID if the event is tagged with one, and otherwise
+// the CID. Untagged events belong to the last untagged provide operation.
+func statsKey(event Event) string {
+	if event.OperationID() != "" {
+		return event.OperationID()
+	}
+	if event.ContentID().Defined() {
+		return event.ContentID().String()
+	}
+	return ""
+}
+
+func (ss *StatsSink) Consume(event Event) error {
+	switch evt := event.(type) {
+	case *ProvideStart:
+		stats := &provideStats{
+			cid:       evt.ContentID(),
+			round:     evt.Round,
+			start:     evt.TimeStamp(),
+			contacted: map[peer.ID]struct{}{},
+			errors:    map[ErrorClass]int{},
+			dials:     map[string]*DialStats{},
+		}
+		ss.provides[statsKey(event)] = stats
+		ss.order = append(ss.order, stats)
+		return nil
+	case *DialEnd:
+		if evt.Err == nil {
+			ss.dialed[evt.PeerID()] = evt.Maddr
+		}
+	case *MonitorProviderStart:
+		if _, found := ss.records[evt.PeerID()]; !found {
+			ss.records[evt.PeerID()] = nil
+		}
+		return nil
+	case *MonitorProviderEnd:
+		if t, found := ss.records[evt.PeerID()]; found && t == nil && evt.Err == nil {
+			recordTime := evt.TimeStamp()
+			ss.records[evt.PeerID()] = &recordTime
+		}
+		return nil
+	case *ProviderFound:
+		rs := ss.retrieval(evt.Round)
+		if rs.summary.TimeToFirstProvider == nil {
+			ttfp := evt.Duration.Seconds()
+			rs.summary.TimeToFirstProvider = &ttfp
+			rs.firstProvider = evt.TimeStamp()
+		}
+		return nil
+	case *RetrievalEnd:
+		rs := ss.retrieval(evt.Round)
+		rs.summary.Duration = evt.Duration.Seconds()
+		rs.summary.Hops = evt.Hops
+		rs.summary.PeersQueried = evt.PeersQueried
+		if evt.Err != nil {
+			rs.summary.Error = evt.Err.Error()
+		}
+		return nil
+	}
+
+	// Events that arrive after the provide operation has
+	// finished (e.g. of a routing table refresh) aren't counted.
+	stats, found := ss.provides[statsKey(event)]
+	if !found || stats.done {
+		return nil
+	}
+	stats.consume(event)
+
+	return nil
+}
+
+// consume updates the counters of the provide operation with the given event.
+func (ps *provideStats) consume(event Event) {
+	switch evt := event.(type) {
+	case *LookupDone:
+		ps.lookupDone = evt.TimeStamp()
+		ps.closestPeers = evt.ClosestPeers
+	case *AddProviderStart:
+		ps.addProviderStart = evt.TimeStamp()
+	case *ProvideEnd:
+		ps.end = evt.TimeStamp()
+		ps.done = true
+		ps.peersSent = evt.PeersSent
+		ps.err = evt.Err
+	case *DialEnd:
+		stats, found := ps.dials[evt.Transport]
+		if !found {
+			stats = &DialStats{Errors: map[ErrorClass]int{}}
+			ps.dials[evt.Transport] = stats
+		}
+		stats.Attempts += 1
+		if evt.Err == nil {
+			stats.Successes += 1
+		} else {
+			stats.Failures += 1
+			stats.Errors[ClassifyError(evt.Err)] += 1
+			ps.errors[ClassifyError(evt.Err)] += 1
+		}
+	case *SendRequestStart:
+		ps.contacted[evt.PeerID()] = struct{}{}
+		if evt.Request.Type == pb.Message_FIND_NODE {
+			ps.findNodeRequests += 1
+		}
+	case *SendRequestEnd:
+		// Only failed requests of the FIND_NODE walk are counted.
+		if ps.lookupDone.IsZero() && evt.Err != nil {
+			ps.findNodeErrors += 1
+			ps.errors[ClassifyError(evt.Err)] += 1
+		}
+	case *SendMessageStart:
+		ps.contacted[evt.PeerID()] = struct{}{}
+		if evt.Message.Type == pb.Message_ADD_PROVIDER {
+			ps.addProviderMessages += 1
+		}
+	case *SendMessageEnd:
+		if evt.Err != nil {
+			ps.addProviderErrors += 1
+			ps.errors[ClassifyError(evt.Err)] += 1
+		}
+	}
+}
+
+// dialCounts returns the number of attributed dials and failed dials of all transports.
+func (ps *provideStats) dialCounts() (int, int) {
+	var attempts, failures int
+	for _, stats := range ps.dials {
+		attempts += stats.Attempts
+		failures += stats.Failures
+	}
+	return attempts, failures
+}
+
+func (ss *StatsSink) retrieval(round int) *retrievalStats {
+	if _, found := ss.retrievals[round]; !found {
+		ss.retrievals[round] = &retrievalStats{summary: &RetrievalSummary{Round: round}}
+		ss.retrievalOrder = append(ss.retrievalOrder, ss.retrievals[round])
+	}
+	return ss.retrievals[round]
+}
+
+func (ss *StatsSink) Close() error {
+	return nil
+}
+
+// DialedAddrs returns the multi address of the last successful dial of every peer.
+func (ss *StatsSink) DialedAddrs() map[peer.ID]ma.Multiaddr {
+	addrs := map[peer.ID]ma.Multiaddr{}
+	for p, maddr := range ss.dialed {
+		addrs[p] = maddr
+	}
+	return addrs
+}
+
+// ProvideSummary holds statistics of a single provide operation. All
+// times are in seconds relative to the start of the provide operation.
+type ProvideSummary struct {
+	// ProvideDuration is the time between the ProvideStart and ProvideEnd events.
+	ProvideDuration float64 `json:"provide_duration_s"`
+
+	// FindNodeDuration is the time of the FIND_NODE walk until the LookupDone event.
+	FindNodeDuration float64 `json:"find_node_duration_s"`
+
+	// AddProviderDuration is the time between the AddProviderStart and ProvideEnd events.
+	AddProviderDuration float64 `json:"add_provider_duration_s"`
+
+	ClosestPeers        int `json:"closest_peers"`
+	PeersSent           int `json:"peers_sent"`
+	PeersContacted      int `json:"peers_contacted"`
+	FindNodeRequests    int `json:"find_node_requests"`
+	FindNodeErrors      int `json:"find_node_errors"`
+	AddProviderMessages int `json:"add_provider_messages"`
+	AddProviderErrors   int `json:"add_provider_errors"`
+
+	// Errors holds the number of failed dials, FIND_NODE requests
+	// and ADD_PROVIDER messages by error class.
+	Errors map[ErrorClass]int `json:"errors"`
+
+	// Dials holds the statistics of the dials of the provide by transport.
+	// Dials of other operations, e.g. the bootstrap, are not included.
+	Dials map[string]*DialStats `json:"dials"`
+
+	// RecordTimes maps the monitored closest peers to the time at which they
+	// first returned the provider record. It is null if they never did.
+	RecordTimes  map[string]*float64 `json:"record_times_s"`
+	TimeToRecord Distribution        `json:"time_to_record_s"`
+
+	// Retrievals holds the results of the FindProviders lookups
+	// that were performed after the content was provided.
+	Retrievals []*RetrievalSummary `json:"retrievals,omitempty"`
+
+	// Reprovides holds the results of the reprovide rounds
+	// that were performed after the initial provide.
+	Reprovides []*ReprovideSummary `json:"reprovides,omitempty"`
+}
+
+// ReprovideSummary holds the results of a single reprovide round.
+type ReprovideSummary struct {
+	Round            int     `json:"round"`
+	Start            float64 `json:"start_s"`
+	Duration         float64 `json:"duration_s"`
+	FindNodeDuration float64 `json:"find_node_duration_s"`
+	ClosestPeers     int     `json:"closest_peers"`
+	PeersSent        int     `json:"peers_sent"`
+	Error            string  `json:"error,omitempty"`
+
+	// Added and Removed hold the peers that joined or left the
+	// set of closest peers compared to the previous round.
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// RetrievalSummary holds the results of a single FindProviders lookup.
+type RetrievalSummary struct {
+	Round        int     `json:"round"`
+	Duration     float64 `json:"duration_s"`
+	Hops         int     `json:"hops"`
+	PeersQueried int     `json:"peers_queried"`
+	Error        string  `json:"error,omitempty"`
+
+	// TimeToFirstProvider is the time from the start of the lookup
+	// until the first provider was found. It is null if none was found.
+	TimeToFirstProvider *float64 `json:"time_to_first_provider_s"`
+
+	// PublishToDiscover is the time from the start of the provide operation
+	// until the first provider was found. It is null if none was found.
+	PublishToDiscover *float64 `json:"publish_to_discover_s"`
+}
+
+// DialStats counts the dial outcomes of a single transport.
+type DialStats struct {
+	Attempts    int     `json:"attempts"`
+	Successes   int     `json:"successes"`
+	Failures    int     `json:"failures"`
+	SuccessRate float64 `json:"success_rate"`
+
+	// Errors holds the number of failures by error class.
+	Errors map[ErrorClass]int `json:"errors,omitempty"`
+}
+
+// NewProvideSummary derives the summary of a provide operation that started
+// at the given time from the given events. Dials and requests that aren't
+// attributed to the provide operation (e.g. of the bootstrap) are ignored.
+func NewProvideSummary(events []Event, start time.Time) *ProvideSummary {
+	return consumeSorted(events).Summary(start)
+}
+
+// consumeSorted returns a stats sink that consumed the given events
+// in the order of their timestamps.
+func consumeSorted(events []Event) *StatsSink {
+	sorted := make([]Event, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].TimeStamp().Before(sorted[j].TimeStamp())
+	})
+
+	ss := NewStatsSink()
+	for _, evt := range sorted {
+		_ = ss.Consume(evt)
+	}
+	return ss
+}
+
+// Summary computes the summary of the initial provide operation. The
+// monitoring and retrieval results are relative to the given start time.
+func (ss *StatsSink) Summary(start time.Time) *ProvideSummary {
+	ps := &ProvideSummary{
+		Dials:       map[string]*DialStats{},
+		RecordTimes: map[string]*float64{},
+		Errors:      map[ErrorClass]int{},
+	}
+
+	var initial *provideStats
+	for _, stats := range ss.order {
+		if stats.round == 0 {
+			initial = stats
+			break
+		}
+	}
+
+	if initial != nil {
+		if !initial.lookupDone.IsZero() {
+			ps.FindNodeDuration = initial.lookupDone.Sub(initial.start).Seconds()
+			ps.ClosestPeers = len(initial.closestPeers)
+		}
+		if initial.done {
+			ps.ProvideDuration = initial.end.Sub(initial.start).Seconds()
+			ps.PeersSent = initial.peersSent
+			if !initial.addProviderStart.IsZero() {
+				ps.AddProviderDuration = initial.end.Sub(initial.addProviderStart).Seconds()
+			}
+		}
+		ps.PeersContacted = len(initial.contacted)
+		ps.FindNodeRequests = initial.findNodeRequests
+		ps.FindNodeErrors = initial.findNodeErrors
+		ps.AddProviderMessages = initial.addProviderMessages
+		ps.AddProviderErrors = initial.addProviderErrors
+		for class, count := range initial.errors {
+			ps.Errors[class] = count
+		}
+		for transport, stats := range initial.dials {
+			ps.Dials[transport] = stats
+			stats.SuccessRate = float64(stats.Successes) / float64(stats.Attempts)
+		}
+	}
+
+	var recordTimes []float64
+	for p, t := range ss.records {
+		if t == nil {
+			ps.RecordTimes[p.Pretty()] = nil
+			continue
+		}
+		recordTime := t.Sub(start).Seconds()
+		ps.RecordTimes[p.Pretty()] = &recordTime
+		recordTimes = append(recordTimes, recordTime)
+	}
+	ps.TimeToRecord = NewDistribution(recordTimes)
+
+	for _, rs := range ss.retrievalOrder {
+		if initial != nil && !rs.firstProvider.IsZero() {
+			p2d := rs.firstProvider.Sub(initial.start).Seconds()
+			rs.summary.PublishToDiscover = &p2d
+		}
+		ps.Retrievals = append(ps.Retrievals, rs.summary)
+	}
+	ps.Reprovides = ss.reprovideSummaries(start)
+
+	return ps
+}
+
+// reprovideSummaries derives the results of all reprovide rounds. The
+// closest peers of every round are compared with those of the previous round.
+func (ss *StatsSink) reprovideSummaries(start time.Time) []*ReprovideSummary {
+	var summaries []*ReprovideSummary
+	closest := map[int][]peer.ID{}
+
+	for _, stats := range ss.order {
+		if !stats.lookupDone.IsZero() {
+			closest[stats.round] = stats.closestPeers
+		}
+		if stats.round == 0 {
+			continue
+		}
+
+		rs := &ReprovideSummary{
+			Round:   stats.round,
+			Start:   stats.start.Sub(start).Seconds(),
+			Added:   []string{},
+			Removed: []string{},
+		}
+		summaries = append(summaries, rs)
+
+		if !stats.lookupDone.IsZero() {
+			rs.FindNodeDuration = stats.lookupDone.Sub(stats.start).Seconds()
+			rs.ClosestPeers = len(stats.closestPeers)
+
+			previous := map[peer.ID]struct{}{}
+			for _, p := range closest[stats.round-1] {
+				previous[p] = struct{}{}
+			}
+			current := map[peer.ID]struct{}{}
+			for _, p := range stats.closestPeers {
+				current[p] = struct{}{}
+				if _, found := previous[p]; !found {
+					rs.Added = append(rs.Added, p.Pretty())
+				}
+			}
+			for p := range previous {
+				if _, found := current[p]; !found {
+					rs.Removed = append(rs.Removed, p.Pretty())
+				}
+			}
+		}
+
+		if stats.done {
+			rs.Duration = stats.end.Sub(stats.start).Seconds()
+			rs.PeersSent = stats.peersSent
+			if stats.err != nil {
+				rs.Error = stats.err.Error()
+			}
+		}
+	}
+
+	return summaries
+}
+
+// Save writes the summary as JSON to the given file.
+func (ps *ProvideSummary) Save(filename string) error {
+	return writeJSON(filename, ps)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+func TestNewProvideSummaryDials(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	self := test.RandPeerIDFatal(t)
+	provided := test.RandPeerIDFatal(t)
+	monitored := test.RandPeerIDFatal(t)
+	maddr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	find := pb.NewMessage(pb.Message_FIND_NODE, []byte("key"), 0)
+
+	events := []Event{
+		// Dial of the bootstrap before the provide started.
+		&DialEnd{BaseEvent: BaseEvent{ID: provided, Time: at(-5)}, Transport: "tcp", Maddr: maddr},
+		&ProvideStart{BaseEvent: BaseEvent{ID: self, Time: at(0), Operation: "provide-1"}},
+		&DialEnd{BaseEvent: BaseEvent{ID: provided, Time: at(1), Operation: "provide-1"}, Transport: "tcp", Maddr: maddr},
+		&DialEnd{BaseEvent: BaseEvent{ID: provided, Time: at(2), Operation: "provide-1"}, Transport: "ws", Maddr: maddr, Err: errors.New("dial backoff")},
+		&SendRequestStart{BaseEvent: BaseEvent{ID: provided, Time: at(3), Operation: "provide-1"}, Request: find},
+		&SendRequestEnd{BaseEvent: BaseEvent{ID: provided, Time: at(4), Operation: "provide-1"}, Response: find},
+		// Dial of a peer that the monitor contacted.
+		&DialEnd{BaseEvent: BaseEvent{ID: monitored, Time: at(5), Operation: "monitor-2"}, Transport: "tcp", Maddr: maddr},
+		&SendRequestStart{BaseEvent: BaseEvent{ID: monitored, Time: at(6), Operation: "monitor-2"}, Request: find},
+		&LookupDone{BaseEvent: BaseEvent{ID: self, Time: at(7), Operation: "provide-1"}, ClosestPeers: []peer.ID{provided}},
+		&AddProviderStart{BaseEvent: BaseEvent{ID: self, Time: at(7), Operation: "provide-1"}},
+		&ProvideEnd{BaseEvent: BaseEvent{ID: self, Time: at(8), Operation: "provide-1"}, PeersSent: 1},
+		// Dial of a routing table refresh during the provide.
+		&DialEnd{BaseEvent: BaseEvent{ID: provided, Time: at(7)}, Transport: "tcp", Maddr: maddr},
+	}
+
+	ps := NewProvideSummary(events, start)
+
+	tcp, ws := ps.Dials["tcp"], ps.Dials["ws"]
+	if tcp == nil || tcp.Attempts != 1 || tcp.Successes != 1 {
+		t.Errorf("tcp dials = %+v, want 1 successful attempt", tcp)
+	}
+	if ws == nil || ws.Attempts != 1 || ws.Failures != 1 || ws.Errors[ErrorClassDialBackoff] != 1 {
+		t.Errorf("ws dials = %+v, want 1 failed attempt with dial backoff", ws)
+	}
+	if ps.FindNodeRequests != 1 || ps.PeersContacted != 1 || ps.PeersSent != 1 {
+		t.Errorf("find node requests/contacted/sent = %d/%d/%d, want 1/1/1", ps.FindNodeRequests, ps.PeersContacted, ps.PeersSent)
+	}
+}
+
+func TestNewReprovideSummaries(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	self := test.RandPeerIDFatal(t)
+	a, b, c := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	events := []Event{
+		&ProvideStart{BaseEvent: BaseEvent{ID: self, Time: at(0)}, Round: 0},
+		&LookupDone{BaseEvent: BaseEvent{ID: self, Time: at(10)}, Round: 0, ClosestPeers: []peer.ID{a, b}},
+		&ProvideEnd{BaseEvent: BaseEvent{ID: self, Time: at(20)}, Round: 0, PeersSent: 2},
+		&ProvideStart{BaseEvent: BaseEvent{ID: self, Time: at(100)}, Round: 1},
+		&LookupDone{BaseEvent: BaseEvent{ID: self, Time: at(130)}, Round: 1, ClosestPeers: []peer.ID{b, c}},
+		&ProvideEnd{BaseEvent: BaseEvent{ID: self, Time: at(150)}, Round: 1, PeersSent: 1, Err: errors.New("context canceled")},
+	}
+
+	summaries := NewProvideSummary(events, start).Reprovides
+	if len(summaries) != 1 {
+		t.Fatalf("got %d reprovide summaries, want 1", len(summaries))
+	}
+
+	rs := summaries[0]
+	if rs.Round != 1 || rs.ClosestPeers != 2 || rs.PeersSent != 1 || rs.Error != "context canceled" {
+		t.Errorf("summary = %+v", rs)
+	}
+	if !approx(rs.Start, 0.1) || !approx(rs.FindNodeDuration, 0.03) || !approx(rs.Duration, 0.05) {
+		t.Errorf("start/find node/duration = %f/%f/%f, want 0.1/0.03/0.05", rs.Start, rs.FindNodeDuration, rs.Duration)
+	}
+	if len(rs.Added) != 1 || rs.Added[0] != c.Pretty() {
+		t.Errorf("added = %v, want [%s]", rs.Added, c.Pretty())
+	}
+	if len(rs.Removed) != 1 || rs.Removed[0] != a.Pretty() {
+		t.Errorf("removed = %v, want [%s]", rs.Removed, a.Pretty())
+	}
+}
+
+// approx returns true if the given values differ by less than a microsecond.
+func approx(a, b float64) bool {
+	return a-b < 1e-6 && b-a < 1e-6
+}
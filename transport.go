@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/transport"
 	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
@@ -12,6 +16,63 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 )
 
+// Transports maps the names of the transports that can be enabled for the
+// provider to the libp2p options of their instrumented implementations
+// including the addresses they listen on. Transports that depend on
+// build tags register themselves in init functions.
+var Transports = map[string]func(eh *EventHub) libp2p.Option{
+	"tcp": func(eh *EventHub) libp2p.Option {
+		return libp2p.ChainOptions(
+			libp2p.Transport(NewTCPTransport(eh)),
+			libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0", "/ip6/::/tcp/0"),
+		)
+	},
+	"ws": func(eh *EventHub) libp2p.Option {
+		return libp2p.ChainOptions(
+			libp2p.Transport(NewWSTransport(eh)),
+			libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0/ws", "/ip6/::/tcp/0/ws"),
+		)
+	},
+}
+
+// DefaultTransports are the transports that are enabled if the user doesn't choose any.
+var DefaultTransports = []string{"tcp", "ws"}
+
+// transportOptions returns the libp2p options that enable the given transports.
+func transportOptions(eh *EventHub, names []string) libp2p.Option {
+	opts := []libp2p.Option{libp2p.NoListenAddrs}
+	for _, name := range names {
+		opts = append(opts, Transports[name](eh))
+	}
+	return libp2p.ChainOptions(opts...)
+}
+
+// parseTransports checks that all given transport names are known.
+func parseTransports(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return DefaultTransports, nil
+	}
+
+	for _, name := range names {
+		if _, found := Transports[name]; found {
+			continue
+		}
+
+		if name == "quic" {
+			return nil, fmt.Errorf("transport quic is only available in builds with -tags quic")
+		}
+
+		known := make([]string, 0, len(Transports))
+		for transport := range Transports {
+			known = append(known, transport)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown transport %q, supported are %s", name, strings.Join(known, ", "))
+	}
+
+	return names, nil
+}
+
 // TCPTransport is a thin wrapper around the actual *tcp.TcpTransport implementation.
 // It intercepts calls to Dial to track when which peer is dialed.
 type TCPTransport struct {
@@ -23,7 +84,7 @@ func NewTCPTransport(eh *EventHub) func(upgrader *tptu.Upgrader) *TCPTransport {
 	return func(upgrader *tptu.Upgrader) *TCPTransport {
 		return &TCPTransport{
 			eventHub:  eh,
-			transport: tcp.NewTCPTransport(upgrader),
+			transport: tcp.NewTCPTransport(instrumentUpgrader(eh, "tcp", upgrader)),
 		}
 	}
 }
@@ -77,7 +138,7 @@ func NewWSTransport(eh *EventHub) func(upgrader *tptu.Upgrader) *WSTransport {
 	return func(upgrader *tptu.Upgrader) *WSTransport {
 		return &WSTransport{
 			eventHub:  eh,
-			transport: websocket.New(upgrader),
+			transport: websocket.New(instrumentUpgrader(eh, "ws", upgrader)),
 		}
 	}
 }
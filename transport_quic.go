@@ -0,0 +1,99 @@
+//go:build quic
+// +build quic
+
+// The QUIC transport depends on quic-go v0.21, which only builds with
+// Go 1.15 to 1.17. It's therefore only compiled in with -tags quic.
+
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/pnet"
+	"github.com/libp2p/go-libp2p-core/transport"
+	quic "github.com/libp2p/go-libp2p-quic-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func init() {
+	Transports["quic"] = func(eh *EventHub) libp2p.Option {
+		return libp2p.ChainOptions(
+			libp2p.Transport(NewQUICTransport(eh)),
+			libp2p.ListenAddrStrings("/ip4/0.0.0.0/udp/0/quic", "/ip6/::/udp/0/quic"),
+		)
+	}
+}
+
+// QUICTransport is a thin wrapper around the actual QUIC transport implementation.
+// It intercepts calls to Dial to track when which peer is dialed. QUIC comes with
+// its own security handshake and stream multiplexing, so there is no upgrader
+// whose phases could be tracked separately.
+type QUICTransport struct {
+	eventHub  *EventHub
+	transport transport.Transport
+}
+
+func NewQUICTransport(eh *EventHub) func(key crypto.PrivKey, psk pnet.PSK, gater connmgr.ConnectionGater) (*QUICTransport, error) {
+	return func(key crypto.PrivKey, psk pnet.PSK, gater connmgr.ConnectionGater) (*QUICTransport, error) {
+		t, err := quic.NewTransport(key, psk, gater)
+		if err != nil {
+			return nil, err
+		}
+		return &QUICTransport{
+			eventHub:  eh,
+			transport: t,
+		}, nil
+	}
+}
+
+func (q *QUICTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	q.eventHub.PushEvent(&DialStart{
+		BaseEvent: BaseEvent{
+			ID:   p,
+			Time: time.Now(),
+		},
+		Transport: "quic",
+		Maddr:     raddr,
+	})
+	dial, err := q.transport.Dial(ctx, raddr, p)
+	q.eventHub.PushEvent(&DialEnd{
+		BaseEvent: BaseEvent{
+			ID:   p,
+			Time: time.Now(),
+		},
+		Transport: "quic",
+		Maddr:     raddr,
+		Err:       err,
+	})
+	return dial, err
+}
+
+func (q *QUICTransport) CanDial(addr ma.Multiaddr) bool {
+	return q.transport.CanDial(addr)
+}
+
+func (q *QUICTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	return q.transport.Listen(laddr)
+}
+
+func (q *QUICTransport) Protocols() []int {
+	return q.transport.Protocols()
+}
+
+func (q *QUICTransport) Proxy() bool {
+	return q.transport.Proxy()
+}
+
+// Close closes the UDP sockets of the underlying transport.
+func (q *QUICTransport) Close() error {
+	if closer, ok := q.transport.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
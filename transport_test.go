@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+)
+
+func TestParseTransports(t *testing.T) {
+	tests := []struct {
+		names   []string
+		want    []string
+		wantErr bool
+	}{
+		{names: nil, want: DefaultTransports},
+		{names: []string{"tcp"}, want: []string{"tcp"}},
+		{names: []string{"ws", "tcp"}, want: []string{"ws", "tcp"}},
+		{names: []string{"quic"}, want: []string{"quic"}, wantErr: Transports["quic"] == nil},
+		{names: []string{"tcp", "udp"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTransports(tt.names)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTransports(%v) succeeded, want error", tt.names)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseTransports(%v): %s", tt.names, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTransports(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestTransportOptionsListen(t *testing.T) {
+	for name := range Transports {
+		h, err := libp2p.New(context.Background(), transportOptions(nil, []string{name}))
+		if err != nil {
+			t.Fatalf("new host with transport %s: %s", name, err)
+		}
+		if len(h.Addrs()) == 0 {
+			t.Errorf("host with transport %s has no listen addresses", name)
+		}
+		h.Close()
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/sec"
+	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// securityProtocols maps the packages of the secure connection
+// implementations to the protocol IDs they are negotiated with.
+var securityProtocols = map[string]string{
+	"github.com/libp2p/go-libp2p-noise":             "/noise",
+	"github.com/libp2p/go-libp2p-tls":               "/tls/1.0.0",
+	"github.com/libp2p/go-libp2p-secio":             "/secio/1.0.0",
+	"github.com/libp2p/go-libp2p-core/sec/insecure": "/plaintext/2.0.0",
+}
+
+// muxerProtocols maps the packages of the multiplexed connection
+// implementations to the protocol IDs they are negotiated with.
+var muxerProtocols = map[string]string{
+	"github.com/libp2p/go-libp2p-yamux": "/yamux/1.0.0",
+	"github.com/libp2p/go-libp2p-mplex": "/mplex/6.7.0",
+}
+
+// negotiatedProtocol looks up the protocol ID of the implementation of the
+// given connection in the given map. The multistream muxers don't expose
+// the negotiated protocol, so it's derived from the package of the type.
+// Unknown implementations are identified by their type name.
+func negotiatedProtocol(conn interface{}, protocols map[string]string) string {
+	t := reflect.TypeOf(conn)
+	if t == nil {
+		return ""
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if protocol, found := protocols[t.PkgPath()]; found {
+		return protocol
+	}
+	return fmt.Sprintf("%T", conn)
+}
+
+// instrumentUpgrader returns a copy of the given upgrader that dispatches
+// events for the security handshake and the stream multiplexer negotiation
+// of all outbound connections of the given transport.
+func instrumentUpgrader(eh *EventHub, transport string, upgrader *tptu.Upgrader) *tptu.Upgrader {
+	instrumented := *upgrader
+	instrumented.Secure = &secureMuxer{
+		eventHub:  eh,
+		transport: transport,
+		sm:        upgrader.Secure,
+	}
+	instrumented.Muxer = &multiplexer{
+		eventHub:  eh,
+		transport: transport,
+		m:         upgrader.Muxer,
+	}
+	return &instrumented
+}
+
+// secureMuxer is a thin wrapper around the multistream security muxer of
+// the upgrader. It intercepts calls to SecureOutbound to track when the
+// raw connection is established and how long the security handshake takes.
+type secureMuxer struct {
+	eventHub  *EventHub
+	transport string
+	sm        sec.SecureMuxer
+}
+
+func (s *secureMuxer) SecureInbound(ctx context.Context, insecure net.Conn) (sec.SecureConn, bool, error) {
+	return s.sm.SecureInbound(ctx, insecure)
+}
+
+func (s *secureMuxer) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, bool, error) {
+	var maddr ma.Multiaddr
+	if mc, ok := insecure.(interface{ RemoteMultiaddr() ma.Multiaddr }); ok {
+		maddr = mc.RemoteMultiaddr()
+	}
+
+	s.eventHub.PushEvent(&ConnectEnd{
+		BaseEvent: BaseEvent{
+			ID:   p,
+			Time: time.Now(),
+		},
+		Transport: s.transport,
+		Maddr:     maddr,
+	})
+	s.eventHub.PushEvent(&SecurityStart{
+		BaseEvent: BaseEvent{
+			ID:   p,
+			Time: time.Now(),
+		},
+		Transport: s.transport,
+		Maddr:     maddr,
+	})
+	sconn, server, err := s.sm.SecureOutbound(ctx, insecure, p)
+	s.eventHub.PushEvent(&SecurityEnd{
+		BaseEvent: BaseEvent{
+			ID:   p,
+			Time: time.Now(),
+		},
+		Transport: s.transport,
+		Maddr:     maddr,
+		Protocol:  negotiatedProtocol(sconn, securityProtocols),
+		Err:       err,
+	})
+	if err != nil {
+		return nil, server, err
+	}
+
+	return &outboundSecureConn{
+		SecureConn: sconn,
+		maddr:      maddr,
+	}, server, nil
+}
+
+// outboundSecureConn is a secured outbound connection that carries the
+// remote multi address, because the stream multiplexer only gets
+// passed the connection.
+type outboundSecureConn struct {
+	sec.SecureConn
+	maddr ma.Multiaddr
+}
+
+// multiplexer is a thin wrapper around the multistream stream multiplexer of
+// the upgrader. It intercepts calls to NewConn to track how long the stream
+// multiplexer negotiation of outbound connections takes.
+type multiplexer struct {
+	eventHub  *EventHub
+	transport string
+	m         mux.Multiplexer
+}
+
+func (m *multiplexer) NewConn(c net.Conn, isServer bool) (mux.MuxedConn, error) {
+	oc, ok := c.(*outboundSecureConn)
+	if !ok {
+		return m.m.NewConn(c, isServer)
+	}
+
+	m.eventHub.PushEvent(&MuxerStart{
+		BaseEvent: BaseEvent{
+			ID:   oc.RemotePeer(),
+			Time: time.Now(),
+		},
+		Transport: m.transport,
+		Maddr:     oc.maddr,
+	})
+	mconn, err := m.m.NewConn(c, isServer)
+	m.eventHub.PushEvent(&MuxerEnd{
+		BaseEvent: BaseEvent{
+			ID:   oc.RemotePeer(),
+			Time: time.Now(),
+		},
+		Transport: m.transport,
+		Maddr:     oc.maddr,
+		Protocol:  negotiatedProtocol(mconn, muxerProtocols),
+		Err:       err,
+	})
+	return mconn, err
+}